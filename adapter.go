@@ -3,13 +3,17 @@ package sqlxx
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"runtime/debug"
 
 	"github.com/jmoiron/sqlx"
-	"github.com/pkg/errors"
 )
 
 type (
-	TxKey struct{}
+	// TxKey is the context key of the transaction of the database named Name.
+	TxKey struct {
+		Name string
+	}
 )
 
 func NewWith(sqlxDB *sqlx.DB) *Sqlxx {
@@ -21,15 +25,49 @@ func NewWith(sqlxDB *sqlx.DB) *Sqlxx {
 }
 
 func NewWithCluster(masters, slaves []*sqlx.DB) *Sqlxx {
+	return New(NewRRCluster(masters, slaves))
+}
+
+func New(cluster *Cluster) *Sqlxx {
+	return &Sqlxx{
+		db: &DB{
+			Cluster: cluster,
+		},
+	}
+}
+
+func NewWithShards(shards *ShardedCluster) *Sqlxx {
+	return &Sqlxx{
+		db: &DB{
+			resolver: shards,
+		},
+	}
+}
+
+func NewWithTenants(tenants *TenantRegistry) *Sqlxx {
 	return &Sqlxx{
 		db: &DB{
-			Cluster: NewRRCluster(masters, slaves),
+			resolver: tenants,
 		},
 	}
 }
 
 type Sqlxx struct {
-	db *DB
+	db       *DB
+	name     string
+	registry *Registry
+}
+
+func (adapter *Sqlxx) Name() string {
+	return adapter.name
+}
+
+// Use returns the database registered as name on the registry of adapter.
+func (adapter *Sqlxx) Use(name string) *Sqlxx {
+	if adapter.registry != nil {
+		return adapter.registry.Use(name)
+	}
+	return defaultRegistry.Use(name)
 }
 
 func (adapter *Sqlxx) GetDB(ctx context.Context) *DB {
@@ -37,48 +75,181 @@ func (adapter *Sqlxx) GetDB(ctx context.Context) *DB {
 	if txDB != nil {
 		return txDB
 	}
+	connDB := adapter.getConn(ctx)
+	if connDB != nil {
+		return connDB
+	}
 	return adapter.db
 }
 
-func (adapter *Sqlxx) ExecuteTx(ctx context.Context, fn func(txCtx context.Context) error, txOpts ...*sql.TxOptions) error {
-	txOpt := &sql.TxOptions{}
-	if len(txOpts) > 0 {
-		txOpt = txOpts[0]
+// Close stops the background workers started on the cluster.
+func (adapter *Sqlxx) Close() error {
+	if adapter.db.resolver != nil {
+		return adapter.db.resolver.Close()
 	}
+	if adapter.db.Cluster == nil {
+		return nil
+	}
+	return adapter.db.Cluster.Close()
+}
 
-	txDB, err := adapter.db.Begin(ctx, txOpt)
-	if err != nil {
+func (adapter *Sqlxx) ExecuteTx(ctx context.Context, fn func(txCtx context.Context) error, opts ...TxOption) error {
+	cfg := newTxConfig(opts...)
+	txDB := adapter.getTx(ctx)
+	switch cfg.propagation {
+	case PropagationNested:
+		if txDB != nil {
+			return adapter.nestedTx(ctx, txDB, fn, cfg)
+		}
+	case PropagationRequired:
+		if txDB != nil {
+			return fn(ctx)
+		}
+	case PropagationMandatory:
+		if txDB == nil {
+			return ErrNoTx
+		}
+		return fn(ctx)
+	case PropagationNever:
+		if txDB != nil {
+			return ErrTxExists
+		}
+		return fn(ctx)
+	case PropagationSupports:
+		return fn(ctx)
+	}
+
+	if cfg.maxAttempts <= 1 {
+		_, err := adapter.executeTx(ctx, fn, cfg)
 		return err
 	}
 
-	txCtx := adapter.withTx(ctx, txDB)
+	for attempt := 1; ; attempt++ {
+		dialect, err := adapter.executeTx(ctx, fn, cfg)
+		if err == nil || attempt >= cfg.maxAttempts || !IsRetryable(dialect, err) {
+			return err
+		}
+		if sleepErr := sleepContext(ctx, retryBackoff(cfg.backoff, attempt)); sleepErr != nil {
+			return err
+		}
+	}
+}
+
+func (adapter *Sqlxx) executeTx(ctx context.Context, fn func(txCtx context.Context) error, cfg *txConfig) (dialect Dialect, err error) {
+	// the timeout cancels the statements of the callback as well as the tx
+	beginCtx := ctx
+	if timeout, ok := GetTxTimeout(ctx); ok {
+		var cancel context.CancelFunc
+		beginCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	baseDB := adapter.db
+	// a RequiresNew tx can not begin on the pinned conn holding the suspended
+	// tx, it begins on a conn of the pool instead
+	if connDB := adapter.getConn(ctx); connDB != nil && !connDB.hasConnTx() {
+		baseDB = connDB
+	}
+	txDB, err := baseDB.Begin(beginCtx, cfg.txOpt)
+	if err != nil {
+		return UnknownDialect, err
+	}
+	dialect = txDB.Dialect()
+
+	txDB.hooks = &txHooks{}
+	txCtx := adapter.withTx(beginCtx, txDB)
+	// a panic after the tx is ended comes from the hooks and is not recovered
+	txEnded := false
 	defer func() {
-		if pErr := recover(); pErr != nil {
-			txDB.Rollback(ctx)
+		if txEnded {
+			return
 		}
+		pErr := recover()
+		if pErr == nil {
+			return
+		}
+		stack := debug.Stack()
+		rbErr := txDB.Rollback(ctx)
+		txDB.hooks.rolledBack(ctx)
+		if !cfg.panicError {
+			panic(pErr)
+		}
+		err = &TxPanicError{Value: pErr, Stack: stack, Rollback: rbErr}
 	}()
-	var callbackErr, txErr error
-	callbackErr = fn(txCtx)
-	if callbackErr != nil {
-		txErr = txDB.Rollback(txCtx)
-	} else {
-		if txErr = txDB.Commit(txCtx); txErr != nil {
-			txErr = txDB.Rollback(txCtx)
+
+	if callbackErr := fn(txCtx); callbackErr != nil {
+		rbErr := txDB.Rollback(txCtx)
+		txEnded = true
+		if errors.Is(rbErr, sql.ErrTxDone) && txCtx.Err() != nil {
+			// the tx is rolled back by database/sql when the timeout expires
+			rbErr = nil
 		}
+		txDB.hooks.rolledBack(ctx)
+		return dialect, &TxError{Callback: callbackErr, Rollback: rbErr}
 	}
+	// a failed commit ends the tx, there is nothing left to roll back
+	commitErr := txDB.Commit(txCtx)
+	txEnded = true
+	if commitErr != nil {
+		if errors.Is(commitErr, sql.ErrTxDone) && txCtx.Err() != nil {
+			commitErr = txCtx.Err()
+		}
+		txDB.hooks.rolledBack(ctx)
+		return dialect, &TxError{Commit: commitErr}
+	}
+	txDB.hooks.committed(ctx)
+	return dialect, nil
+}
 
-	if txErr != nil {
-		if callbackErr != nil {
-			txErr = errors.Wrapf(txErr, "callback error:%+v", callbackErr)
+// nestedTx runs fn in a savepoint of txDB, an error or panic of fn only rolls
+// back the savepoint and the outer tx is left intact.
+func (adapter *Sqlxx) nestedTx(ctx context.Context, txDB *DB, fn func(txCtx context.Context) error, cfg *txConfig) (err error) {
+	savepoint, err := txDB.Savepoint(ctx)
+	if err != nil {
+		return err
+	}
+	// after commit hooks of a rolled back savepoint are dropped
+	mark := txDB.hooks.mark()
+	defer func() {
+		pErr := recover()
+		if pErr == nil {
+			return
 		}
-		return txErr
+		stack := debug.Stack()
+		rbErr := txDB.RollbackToSavepoint(ctx, savepoint)
+		txDB.hooks.truncate(mark)
+		if !cfg.panicError {
+			panic(pErr)
+		}
+		err = &TxPanicError{Value: pErr, Stack: stack, Rollback: rbErr}
+	}()
+
+	if callbackErr := fn(ctx); callbackErr != nil {
+		txDB.hooks.truncate(mark)
+		rbErr := txDB.RollbackToSavepoint(ctx, savepoint)
+		return &TxError{Callback: callbackErr, Rollback: rbErr}
+	}
+	if releaseErr := txDB.ReleaseSavepoint(ctx, savepoint); releaseErr != nil {
+		return &TxError{Commit: releaseErr}
 	}
-	return callbackErr
+	return nil
 }
 
-func (adapter *Sqlxx) ViewTx(ctx context.Context, fn func(ctx context.Context) error, txOpt *sql.TxOptions) error {
+// ViewTx runs fn in a tx on slave, or in a read only tx on master if the session
+// of ctx has to read its own writes. It takes the options of ExecuteTx.
+func (adapter *Sqlxx) ViewTx(ctx context.Context, fn func(ctx context.Context) error, opts ...TxOption) error {
+	if GetSession(ctx).StickToMaster() {
+		readOnlyOpt := sql.TxOptions{ReadOnly: true}
+		if txOpt := newTxConfig(opts...).txOpt; txOpt != nil {
+			readOnlyOpt.Isolation = txOpt.Isolation
+		} else if level, ok := GetIsolation(ctx); ok {
+			readOnlyOpt.Isolation = level
+		}
+		opts = append(opts[:len(opts):len(opts)], WithTxOptions(&readOnlyOpt))
+		return adapter.ExecuteTx(WithMaster(ctx), fn, opts...)
+	}
 	ctx = WithSlave(ctx)
-	return adapter.ExecuteTx(ctx, fn, txOpt)
+	return adapter.ExecuteTx(ctx, fn, opts...)
 }
 
 func (adapter *Sqlxx) HasTx(ctx context.Context) bool {
@@ -87,11 +258,11 @@ func (adapter *Sqlxx) HasTx(ctx context.Context) bool {
 }
 
 func (adapter *Sqlxx) withTx(ctx context.Context, db *DB) context.Context {
-	return context.WithValue(ctx, TxKey{}, db)
+	return context.WithValue(ctx, TxKey{Name: adapter.name}, db)
 }
 
 func (adapter *Sqlxx) getTx(ctx context.Context) *DB {
-	txDB, ok := ctx.Value(TxKey{}).(*DB)
+	txDB, ok := ctx.Value(TxKey{Name: adapter.name}).(*DB)
 	if ok && txDB != nil {
 		return txDB
 	}
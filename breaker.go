@@ -0,0 +1,249 @@
+package sqlxx
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type BreakerState uint8
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+type BreakerConfig struct {
+	// Window is the duration the error and slow rate are counted in, default is 10s
+	Window time.Duration
+	// MinRequests is the requests required in a window before the breaker can trip, default is 20
+	MinRequests int
+	// FailureRate trips the breaker when failures/requests reaches it, default is 0.5
+	FailureRate float64
+	// SlowThreshold marks the requests slower than it as slow, zero disables slow tripping
+	SlowThreshold time.Duration
+	// SlowRate trips the breaker when slow/requests reaches it, default is 0.5
+	SlowRate float64
+	// OpenTimeout is the duration an open breaker waits before probing, default is 5s
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the concurrent probes of a half-open breaker and the
+	// successes required to close it, default is 3
+	HalfOpenRequests int
+	// IsFailure reports whether err counts as a failure, default counts
+	// connection errors and deadline exceeded
+	IsFailure func(err error) bool
+	// OnStateChange is called when the breaker of a node changes state
+	OnStateChange func(db *sqlx.DB, from, to BreakerState)
+}
+
+func (cfg *BreakerConfig) setDefault() {
+	if cfg.Window <= 0 {
+		cfg.Window = 10 * time.Second
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 20
+	}
+	if cfg.FailureRate <= 0 {
+		cfg.FailureRate = 0.5
+	}
+	if cfg.SlowRate <= 0 {
+		cfg.SlowRate = 0.5
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = 5 * time.Second
+	}
+	if cfg.HalfOpenRequests <= 0 {
+		cfg.HalfOpenRequests = 3
+	}
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = isBreakerFailure
+	}
+}
+
+func isBreakerFailure(err error) bool {
+	return IsConnError(err) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// EnableCircuitBreaker wraps every node of the cluster with a circuit breaker,
+// nodes with an open breaker are skipped by the cluster policies.
+func (c *Cluster) EnableCircuitBreaker(cfg BreakerConfig) *CircuitBreaker {
+	cfg.setDefault()
+	cb := &CircuitBreaker{
+		cfg:   cfg,
+		nodes: make(map[*sqlx.DB]*nodeBreaker),
+	}
+	for _, db := range c.Nodes() {
+		cb.nodes[db] = &nodeBreaker{windowStart: time.Now()}
+	}
+
+	c.lock.Lock()
+	c.breaker = cb
+	c.lock.Unlock()
+	return cb
+}
+
+type CircuitBreaker struct {
+	cfg   BreakerConfig
+	nodes map[*sqlx.DB]*nodeBreaker
+}
+
+type nodeBreaker struct {
+	lock        sync.Mutex
+	state       BreakerState
+	windowStart time.Time
+	requests    int
+	failures    int
+	slow        int
+	openedAt    time.Time
+	probes      int
+	probedAt    time.Time
+	successes   int
+}
+
+func (cb *CircuitBreaker) State(db *sqlx.DB) BreakerState {
+	nb, ok := cb.nodes[db]
+	if !ok {
+		return BreakerClosed
+	}
+	nb.lock.Lock()
+	halfOpened := cb.refresh(nb)
+	state := nb.state
+	nb.lock.Unlock()
+
+	if halfOpened {
+		cb.notify(db, BreakerOpen, BreakerHalfOpen)
+	}
+	return state
+}
+
+func (cb *CircuitBreaker) allow(db *sqlx.DB) bool {
+	nb, ok := cb.nodes[db]
+	if !ok {
+		return true
+	}
+	nb.lock.Lock()
+	halfOpened := cb.refresh(nb)
+	allowed := true
+	switch nb.state {
+	case BreakerOpen:
+		allowed = false
+	case BreakerHalfOpen:
+		// recover the probe slots whose outcome is never reported
+		if nb.probes >= cb.cfg.HalfOpenRequests && time.Since(nb.probedAt) > cb.cfg.OpenTimeout {
+			nb.probes = 0
+		}
+		allowed = nb.probes < cb.cfg.HalfOpenRequests
+	}
+	nb.lock.Unlock()
+
+	if halfOpened {
+		cb.notify(db, BreakerOpen, BreakerHalfOpen)
+	}
+	return allowed
+}
+
+func (cb *CircuitBreaker) picked(db *sqlx.DB) {
+	nb, ok := cb.nodes[db]
+	if !ok {
+		return
+	}
+	nb.lock.Lock()
+	defer nb.lock.Unlock()
+	if nb.state == BreakerHalfOpen {
+		nb.probes++
+		nb.probedAt = time.Now()
+	}
+}
+
+// observe counts the outcome of a statement on db, a half-open breaker only
+// counts the probes so a tx is one probe however many statements it runs.
+func (cb *CircuitBreaker) observe(db *sqlx.DB, err error, cost time.Duration, probe bool) {
+	nb, ok := cb.nodes[db]
+	if !ok {
+		return
+	}
+	failed := cb.cfg.IsFailure(err)
+	slow := cb.cfg.SlowThreshold > 0 && cost >= cb.cfg.SlowThreshold
+
+	nb.lock.Lock()
+	from := nb.state
+	switch nb.state {
+	case BreakerClosed:
+		if time.Since(nb.windowStart) > cb.cfg.Window {
+			nb.windowStart, nb.requests, nb.failures, nb.slow = time.Now(), 0, 0, 0
+		}
+		nb.requests++
+		if failed {
+			nb.failures++
+		}
+		if slow {
+			nb.slow++
+		}
+		if nb.requests >= cb.cfg.MinRequests {
+			failureRate := float64(nb.failures) / float64(nb.requests)
+			slowRate := float64(nb.slow) / float64(nb.requests)
+			if failureRate >= cb.cfg.FailureRate || (cb.cfg.SlowThreshold > 0 && slowRate >= cb.cfg.SlowRate) {
+				cb.open(nb)
+			}
+		}
+	case BreakerHalfOpen:
+		if !probe {
+			break
+		}
+		if nb.probes > 0 {
+			nb.probes--
+		}
+		if failed || slow {
+			cb.open(nb)
+			break
+		}
+		nb.successes++
+		if nb.successes >= cb.cfg.HalfOpenRequests {
+			nb.state = BreakerClosed
+			nb.windowStart, nb.requests, nb.failures, nb.slow = time.Now(), 0, 0, 0
+		}
+	}
+	to := nb.state
+	nb.lock.Unlock()
+
+	cb.notify(db, from, to)
+}
+
+// refresh moves an open breaker to half-open after OpenTimeout, nb.lock must be held.
+func (cb *CircuitBreaker) refresh(nb *nodeBreaker) bool {
+	if nb.state != BreakerOpen || time.Since(nb.openedAt) < cb.cfg.OpenTimeout {
+		return false
+	}
+	nb.state = BreakerHalfOpen
+	nb.probes, nb.successes = 0, 0
+	return true
+}
+
+func (cb *CircuitBreaker) open(nb *nodeBreaker) {
+	nb.state = BreakerOpen
+	nb.openedAt = time.Now()
+	nb.probes, nb.successes = 0, 0
+}
+
+func (cb *CircuitBreaker) notify(db *sqlx.DB, from, to BreakerState) {
+	if from != to && cb.cfg.OnStateChange != nil {
+		cb.cfg.OnStateChange(db, from, to)
+	}
+}
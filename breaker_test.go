@@ -0,0 +1,197 @@
+package sqlxx
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type breakerTransition struct {
+	from, to BreakerState
+}
+
+func newTestBreaker(cfg BreakerConfig) (*CircuitBreaker, *sqlx.DB, *[]breakerTransition) {
+	db := &sqlx.DB{}
+	transitions := &[]breakerTransition{}
+	cfg.OnStateChange = func(_ *sqlx.DB, from, to BreakerState) {
+		*transitions = append(*transitions, breakerTransition{from, to})
+	}
+	c := NewRRCluster([]*sqlx.DB{db}, nil)
+	return c.EnableCircuitBreaker(cfg), db, transitions
+}
+
+// expireBreaker moves the open time of db back so the breaker is ready to half-open.
+func expireBreaker(cb *CircuitBreaker, db *sqlx.DB) {
+	nb := cb.nodes[db]
+	nb.lock.Lock()
+	nb.openedAt = nb.openedAt.Add(-cb.cfg.OpenTimeout)
+	nb.lock.Unlock()
+}
+
+func TestCircuitBreaker_Trip(t *testing.T) {
+	cb, db, transitions := newTestBreaker(BreakerConfig{MinRequests: 4, FailureRate: 0.5})
+
+	cb.observe(db, nil, time.Millisecond, true)
+	cb.observe(db, driver.ErrBadConn, time.Millisecond, true)
+	cb.observe(db, errors.New("Error 1062: Duplicate entry"), time.Millisecond, true)
+	assert.Equal(t, BreakerClosed, cb.State(db), "below min requests")
+	assert.True(t, cb.allow(db))
+
+	cb.observe(db, context.DeadlineExceeded, time.Millisecond, true)
+	assert.Equal(t, BreakerOpen, cb.State(db))
+	assert.False(t, cb.allow(db))
+	assert.Equal(t, []breakerTransition{{BreakerClosed, BreakerOpen}}, *transitions)
+
+	// unknown nodes are always allowed
+	assert.True(t, cb.allow(&sqlx.DB{}))
+	assert.Equal(t, BreakerClosed, cb.State(&sqlx.DB{}))
+}
+
+func TestCircuitBreaker_SlowTrip(t *testing.T) {
+	cb, db, _ := newTestBreaker(BreakerConfig{MinRequests: 2, SlowThreshold: 100 * time.Millisecond, SlowRate: 0.5})
+
+	cb.observe(db, nil, time.Millisecond, true)
+	cb.observe(db, nil, time.Millisecond, true)
+	assert.Equal(t, BreakerClosed, cb.State(db))
+	cb.observe(db, nil, time.Second, true)
+	cb.observe(db, nil, time.Second, true)
+	assert.Equal(t, BreakerOpen, cb.State(db))
+}
+
+func TestCircuitBreaker_WindowReset(t *testing.T) {
+	cb, db, _ := newTestBreaker(BreakerConfig{MinRequests: 4, Window: time.Minute})
+
+	for i := 0; i < 3; i++ {
+		cb.observe(db, driver.ErrBadConn, time.Millisecond, true)
+	}
+	nb := cb.nodes[db]
+	nb.lock.Lock()
+	nb.windowStart = nb.windowStart.Add(-2 * time.Minute)
+	nb.lock.Unlock()
+
+	// the failures of the expired window are dropped
+	cb.observe(db, driver.ErrBadConn, time.Millisecond, true)
+	assert.Equal(t, BreakerClosed, cb.State(db))
+	nb.lock.Lock()
+	assert.Equal(t, 1, nb.requests)
+	assert.Equal(t, 1, nb.failures)
+	nb.lock.Unlock()
+}
+
+func TestCircuitBreaker_HalfOpen(t *testing.T) {
+	cb, db, transitions := newTestBreaker(BreakerConfig{MinRequests: 1, HalfOpenRequests: 2})
+
+	cb.observe(db, driver.ErrBadConn, time.Millisecond, true)
+	require.Equal(t, BreakerOpen, cb.State(db))
+	assert.False(t, cb.allow(db))
+
+	expireBreaker(cb, db)
+	assert.True(t, cb.allow(db))
+	assert.Equal(t, BreakerHalfOpen, cb.State(db))
+
+	// only HalfOpenRequests probes at once
+	cb.picked(db)
+	assert.True(t, cb.allow(db))
+	cb.picked(db)
+	assert.False(t, cb.allow(db))
+
+	// a failed probe opens the breaker again
+	cb.observe(db, driver.ErrBadConn, time.Millisecond, true)
+	assert.Equal(t, BreakerOpen, cb.State(db))
+
+	expireBreaker(cb, db)
+	require.True(t, cb.allow(db))
+	for i := 0; i < 2; i++ {
+		cb.picked(db)
+		cb.observe(db, nil, time.Millisecond, true)
+	}
+	assert.Equal(t, BreakerClosed, cb.State(db))
+	assert.True(t, cb.allow(db))
+	assert.Equal(t, []breakerTransition{
+		{BreakerClosed, BreakerOpen},
+		{BreakerOpen, BreakerHalfOpen},
+		{BreakerHalfOpen, BreakerOpen},
+		{BreakerOpen, BreakerHalfOpen},
+		{BreakerHalfOpen, BreakerClosed},
+	}, *transitions)
+
+	// the window starts over after closing
+	nb := cb.nodes[db]
+	nb.lock.Lock()
+	assert.Equal(t, 0, nb.requests)
+	nb.lock.Unlock()
+}
+
+func TestCircuitBreaker_ProbeSlotRecovery(t *testing.T) {
+	cb, db, _ := newTestBreaker(BreakerConfig{MinRequests: 1, HalfOpenRequests: 1})
+
+	cb.observe(db, driver.ErrBadConn, time.Millisecond, true)
+	expireBreaker(cb, db)
+	require.True(t, cb.allow(db))
+	cb.picked(db)
+	assert.False(t, cb.allow(db))
+
+	// the outcome of the probe is never observed, its slot is recovered after OpenTimeout
+	nb := cb.nodes[db]
+	nb.lock.Lock()
+	nb.probedAt = nb.probedAt.Add(-2 * cb.cfg.OpenTimeout)
+	nb.lock.Unlock()
+	assert.True(t, cb.allow(db))
+	assert.Equal(t, BreakerHalfOpen, cb.State(db))
+}
+
+func TestCluster_BreakerSkipsOpenNode(t *testing.T) {
+	a, b := &sqlx.DB{}, &sqlx.DB{}
+	c := NewRRCluster([]*sqlx.DB{a, b}, nil)
+	cb := c.EnableCircuitBreaker(BreakerConfig{MinRequests: 1})
+	cb.observe(a, driver.ErrBadConn, time.Millisecond, true)
+
+	for i := 0; i < 4; i++ {
+		db, err := c.GetDB(context.Background())
+		require.NoError(t, err)
+		assert.Same(t, b, db)
+	}
+
+	cb.observe(b, driver.ErrBadConn, time.Millisecond, true)
+	_, err := c.GetDB(context.Background())
+	assert.Equal(t, ErrNoHealthyNode, err)
+}
+
+func TestCircuitBreaker_HalfOpenTx(t *testing.T) {
+	db, _ := newFakeDB("master", "mysql")
+	adapter := NewWith(db)
+	cb := adapter.db.Cluster.EnableCircuitBreaker(BreakerConfig{MinRequests: 1, HalfOpenRequests: 2})
+	cb.observe(db, driver.ErrBadConn, time.Millisecond, true)
+	expireBreaker(cb, db)
+	require.Equal(t, BreakerHalfOpen, cb.State(db))
+	ctx := context.Background()
+
+	// the statements and commit of a tx are one probe
+	runTx := func() {
+		err := adapter.ExecuteTx(ctx, func(txCtx context.Context) error {
+			for i := 0; i < 3; i++ {
+				if _, err := adapter.GetDB(txCtx).ExecContext(txCtx, "UPDATE users SET name = ?", "a"); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+	}
+	runTx()
+	assert.Equal(t, BreakerHalfOpen, cb.State(db))
+	nb := cb.nodes[db]
+	nb.lock.Lock()
+	assert.Equal(t, 1, nb.successes)
+	assert.Equal(t, 0, nb.probes)
+	nb.lock.Unlock()
+
+	runTx()
+	assert.Equal(t, BreakerClosed, cb.State(db))
+}
@@ -277,6 +277,15 @@ func (builder *QueryBuilder) OrderBy(ss ...string) *QueryBuilder {
 	return builder
 }
 
+// ClearOrderBy removes the ORDER BY set by OrderBy.
+func (builder *QueryBuilder) ClearOrderBy() *QueryBuilder {
+	if builder.err != nil {
+		return builder
+	}
+	builder.otherStmt.order = builder.otherStmt.order[:0]
+	return builder
+}
+
 func (builder *QueryBuilder) LimitOffset(limit, offset int) *QueryBuilder {
 	if builder.err != nil {
 		return builder
@@ -287,6 +296,27 @@ func (builder *QueryBuilder) LimitOffset(limit, offset int) *QueryBuilder {
 	return builder
 }
 
+// GetSelect returns the select columns, it is empty for SELECT *.
+func (builder *QueryBuilder) GetSelect() string {
+	return builder.selectStmt.String()
+}
+
+func (builder *QueryBuilder) GetGroupBy() []string {
+	groups := make([]string, len(builder.otherStmt.group))
+	copy(groups, builder.otherStmt.group)
+	return groups
+}
+
+func (builder *QueryBuilder) GetOrderBy() []string {
+	orders := make([]string, len(builder.otherStmt.order))
+	copy(orders, builder.otherStmt.order)
+	return orders
+}
+
+func (builder *QueryBuilder) GetLimitOffset() (int, int) {
+	return builder.otherStmt.limit, builder.otherStmt.offset
+}
+
 func (builder *QueryBuilder) Lock(s string) *QueryBuilder {
 	if builder.err != nil {
 		return builder
@@ -295,6 +325,33 @@ func (builder *QueryBuilder) Lock(s string) *QueryBuilder {
 	return builder
 }
 
+// SkipLocked skips the rows locked by other tx, FOR UPDATE is used if no lock is
+// set and MSShareLock is changed to FOR SHARE.
+func (builder *QueryBuilder) SkipLocked() *QueryBuilder {
+	return builder.lockOption("SKIP LOCKED")
+}
+
+// NoWait fails at once on the rows locked by other tx, FOR UPDATE is used if no
+// lock is set and MSShareLock is changed to FOR SHARE.
+func (builder *QueryBuilder) NoWait() *QueryBuilder {
+	return builder.lockOption("NOWAIT")
+}
+
+func (builder *QueryBuilder) lockOption(option string) *QueryBuilder {
+	if builder.err != nil {
+		return builder
+	}
+	switch builder.otherStmt.lock {
+	case "":
+		builder.otherStmt.lock = MSWRITELOCK
+	case MSShareLock:
+		// LOCK IN SHARE MODE takes no lock option
+		builder.otherStmt.lock = ShareLock
+	}
+	builder.otherStmt.lock += " " + option
+	return builder
+}
+
 func (builder *QueryBuilder) appendWhereStmt(op, query string, arg interface{}, in bool, options ...Option) {
 	if builder.err != nil {
 		return
@@ -207,6 +207,22 @@ func TestQuery_Other(t *testing.T) {
 			"SELECT id, status FROM users GROUP BY id, status LIMIT 100 OFFSET 1 FOR UPDATE", 0,
 			Query().Select("id, status").From("users").GroupBy("id").LimitOffset(100, 1).GroupBy("status").Lock(string(MSWRITELOCK)),
 		},
+		{
+			"SELECT * FROM jobs WHERE queue = \"mail\" ORDER BY id LIMIT 10 FOR UPDATE SKIP LOCKED", 1,
+			Query().From("jobs").And("queue = ?", "mail").OrderBy("id").LimitOffset(10, 0).SkipLocked(),
+		},
+		{
+			"SELECT * FROM jobs LIMIT 10 FOR SHARE NOWAIT", 0,
+			Query().From("jobs").LimitOffset(10, 0).Lock(MSShareLock).NoWait(),
+		},
+		{
+			"SELECT * FROM jobs LIMIT 10 FOR SHARE SKIP LOCKED", 0,
+			Query().From("jobs").LimitOffset(10, 0).Lock(ShareLock).SkipLocked(),
+		},
+		{
+			"SELECT * FROM jobs LIMIT 10 LOCK IN SHARE MODE", 0,
+			Query().From("jobs").LimitOffset(10, 0).Lock(MSShareLock),
+		},
 	}
 
 	for _, tc := range tcs {
@@ -216,6 +232,30 @@ func TestQuery_Other(t *testing.T) {
 	}
 }
 
+func TestQuery_GetOther(t *testing.T) {
+	q := Query().From("users").OrderBy("id DESC").OrderBy("created_at").LimitOffset(100, 20)
+	assert.Equal(t, []string{"id DESC", "created_at"}, q.GetOrderBy())
+	limit, offset := q.GetLimitOffset()
+	assert.Equal(t, 100, limit)
+	assert.Equal(t, 20, offset)
+
+	cloned := q.Clone().LimitOffset(120, 0)
+	limit, offset = q.GetLimitOffset()
+	assert.Equal(t, 100, limit)
+	assert.Equal(t, 20, offset)
+	limit, offset = cloned.GetLimitOffset()
+	assert.Equal(t, 120, limit)
+	assert.Equal(t, 0, offset)
+
+	cleared := q.Clone().ClearOrderBy()
+	assert.Empty(t, cleared.GetOrderBy())
+	assert.Equal(t, []string{"id DESC", "created_at"}, q.GetOrderBy())
+
+	grouped := Query().Select("user_id", "COUNT(1)").From("orders").GroupBy("user_id")
+	assert.Equal(t, "user_id, COUNT(1)", grouped.GetSelect())
+	assert.Equal(t, []string{"user_id"}, grouped.GetGroupBy())
+}
+
 func TestQuery_Union(t *testing.T) {
 	tcs := []TestCase{
 		{
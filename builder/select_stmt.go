@@ -7,6 +7,8 @@ import (
 const (
 	MSShareLock = "LOCK IN SHARE MODE"
 	MSWRITELOCK = "FOR UPDATE"
+	// ShareLock is the share lock of Postgres and MySQL 8, it takes NOWAIT and SKIP LOCKED
+	ShareLock = "FOR SHARE"
 )
 
 type JoinType string
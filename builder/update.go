@@ -29,13 +29,23 @@ func (builder *UpdateBuilder) Build() (string, []interface{}, error) {
 		return "", nil, err
 	}
 
-	err = builder.whereStmt.Build(query, &args)
+	if builder.Versioned() {
+		err = builder.updateStmt.buildVersion(query, &args, builder.whereStmt)
+	} else {
+		err = builder.whereStmt.Build(query, &args)
+	}
 	if err != nil {
 		return "", nil, err
 	}
 	return query.String(), args, nil
 }
 
+// Versioned reports whether the update is guarded by a version column, which
+// is a field tagged with sqlxx:"version" given to SetWith.
+func (builder *UpdateBuilder) Versioned() bool {
+	return builder.updateStmt.versionCol != ""
+}
+
 func (builder *UpdateBuilder) Table(t string) *UpdateBuilder {
 	builder.updateStmt.table = t
 	return builder
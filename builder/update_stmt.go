@@ -17,6 +17,10 @@ type UpdateStmt struct {
 	strings.Builder
 	table string
 	args  []interface{}
+	// versionCol and version are the version column and the expected version of
+	// an optimistic locking update
+	versionCol string
+	version    interface{}
 }
 
 func (stmt *UpdateStmt) Build(s *strings.Builder, args *[]interface{}) error {
@@ -89,6 +93,13 @@ func (stmt *UpdateStmt) updateWithStruct(data interface{}, options ...Option) er
 			continue
 		}
 		dbValue := val.Field(i).Interface()
+		if valTye.Field(i).Tag.Get("sqlxx") == "version" {
+			err = stmt.setVersion(dbColumn, dbValue)
+			if err != nil {
+				return err
+			}
+			continue
+		}
 		err = stmt.set(dbColumn+" = ?", dbValue, options...)
 		if err != nil {
 			return err
@@ -97,6 +108,34 @@ func (stmt *UpdateStmt) updateWithStruct(data interface{}, options ...Option) er
 	return nil
 }
 
+// setVersion increases the version column, the update only matches the rows of
+// the expected version.
+func (stmt *UpdateStmt) setVersion(dbColumn string, version interface{}) error {
+	err := stmt.set(fmt.Sprintf("%s = %s + 1", dbColumn, dbColumn), nil)
+	if err != nil {
+		return err
+	}
+	stmt.versionCol = dbColumn
+	stmt.version = version
+	return nil
+}
+
+// buildVersion writes the where of a versioned update, the conditions of where
+// are wrapped so an OR can not bypass the version check.
+func (stmt *UpdateStmt) buildVersion(s *strings.Builder, args *[]interface{}, where *WhereStmt) error {
+	whereStmt := where.String()
+	if whereStmt == "" {
+		return errors.New("versioned update should have where conditions")
+	}
+	_, err := s.WriteString(fmt.Sprintf(" WHERE (%s) AND %s = ?", whereStmt, stmt.versionCol))
+	if err != nil {
+		return err
+	}
+	*args = append(*args, where.args...)
+	*args = append(*args, stmt.version)
+	return nil
+}
+
 func (stmt *UpdateStmt) updateWithMap(data map[string]interface{}, options ...Option) error {
 	var err error
 	for dbColumn, dbValue := range data {
@@ -112,8 +151,10 @@ func (stmt *UpdateStmt) clone() *UpdateStmt {
 	copyArgs := make([]interface{}, len(stmt.args))
 	copy(copyArgs, stmt.args)
 	return &UpdateStmt{
-		Builder: strings.Builder{},
-		table:   stmt.table,
-		args:    copyArgs,
+		Builder:    strings.Builder{},
+		table:      stmt.table,
+		args:       copyArgs,
+		versionCol: stmt.versionCol,
+		version:    stmt.version,
 	}
 }
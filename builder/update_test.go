@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
 	"gopkg.in/guregu/null.v4"
 )
 
@@ -47,6 +48,44 @@ func (t User) TableName() string {
 	return "users"
 }
 
+type Order struct {
+	ID      int    `db:"id"`
+	Status  string `db:"status"`
+	Version int    `db:"version" sqlxx:"version"`
+}
+
+func (t Order) TableName() string {
+	return "orders"
+}
+
+func TestUpdate_Version(t *testing.T) {
+	tcs := []TestCase{
+		{
+			`UPDATE orders SET status = "paid", version = version + 1 WHERE (id = 1) AND version = 3`, 3,
+			Update().SetWith(&Order{ID: 0, Status: "paid", Version: 3}, SkipZero).And("id = ?", 1),
+		},
+		{
+			`UPDATE orders SET status = "paid", version = version + 1 WHERE (id = 1 OR id = 2) AND version = 3`, 4,
+			Update().SetWith(&Order{ID: 0, Status: "paid", Version: 3}, SkipZero).And("id = ?", 1).Or("id = ?", 2),
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run("version", func(t *testing.T) {
+			tc.T(t)
+		})
+	}
+
+	// a versioned update without where would update every row of the version
+	_, _, err := Update().SetWith(Order{ID: 1, Status: "paid"}).Build()
+	assert.EqualError(t, err, "versioned update should have where conditions")
+
+	q := Update().SetWith(&Order{Status: "paid", Version: 3}, SkipZero).And("id = ?", 1)
+	assert.True(t, q.Versioned())
+	assert.True(t, q.Clone().Versioned())
+	assert.False(t, Update().SetWith(&User{Name: "vic"}, SkipZero).Versioned())
+}
+
 func TestUpdate_With(t *testing.T) {
 	tcs := []TestCase{
 		{
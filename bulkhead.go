@@ -0,0 +1,173 @@
+package sqlxx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type LaneKey struct{}
+
+// WithLane tags the queries of ctx with lane, lanes have their own concurrency
+// limits on every node.
+func WithLane(ctx context.Context, lane string) context.Context {
+	return context.WithValue(ctx, LaneKey{}, lane)
+}
+
+func GetLane(ctx context.Context) string {
+	lane, _ := ctx.Value(LaneKey{}).(string)
+	return lane
+}
+
+var ErrBulkhead = errors.New("bulkhead limit exceeded")
+
+// BulkheadError is returned when a query cannot get a slot of its node or lane,
+// it matches ErrBulkhead with errors.Is.
+type BulkheadError struct {
+	Lane string
+	// Full is true if the wait queue is full, otherwise the query waited too long
+	Full   bool
+	Waited time.Duration
+}
+
+func (e *BulkheadError) Error() string {
+	scope := "node"
+	if e.Lane != "" {
+		scope = fmt.Sprintf("lane(%s)", e.Lane)
+	}
+	if e.Full {
+		return fmt.Sprintf("%s: %s wait queue is full", ErrBulkhead, scope)
+	}
+	return fmt.Sprintf("%s: %s waited %s", ErrBulkhead, scope, e.Waited)
+}
+
+func (e *BulkheadError) Is(target error) bool {
+	return target == ErrBulkhead
+}
+
+type LaneConfig struct {
+	// MaxConcurrent is the concurrent queries per node, zero is unlimited
+	MaxConcurrent int
+	// MaxWaiting is the queries allowed to wait for a slot, zero is unlimited
+	MaxWaiting int
+	// MaxWait is the longest wait for a slot, default is 1s
+	MaxWait time.Duration
+}
+
+type BulkheadConfig struct {
+	LaneConfig
+	// Lanes are the limits of the named lanes, they are applied before the node limit
+	Lanes map[string]LaneConfig
+}
+
+// EnableBulkhead limits the concurrent queries of every node of the cluster,
+// a tx holds its slot until it is committed or rolled back. The rows returned by
+// NamedQueryContext are not covered, the slot is released before they are read.
+func (c *Cluster) EnableBulkhead(cfg BulkheadConfig) *Bulkhead {
+	bh := &Bulkhead{
+		nodes: make(map[*sqlx.DB]*nodeBulkhead),
+	}
+	for _, db := range c.Nodes() {
+		nb := &nodeBulkhead{
+			node:  newLimiter("", cfg.LaneConfig),
+			lanes: make(map[string]*limiter),
+		}
+		for lane, laneCfg := range cfg.Lanes {
+			nb.lanes[lane] = newLimiter(lane, laneCfg)
+		}
+		bh.nodes[db] = nb
+	}
+
+	c.lock.Lock()
+	c.bulkhead = bh
+	c.lock.Unlock()
+	return bh
+}
+
+type Bulkhead struct {
+	nodes map[*sqlx.DB]*nodeBulkhead
+}
+
+type nodeBulkhead struct {
+	node  *limiter
+	lanes map[string]*limiter
+}
+
+func (bh *Bulkhead) acquire(ctx context.Context, db *sqlx.DB) (func(), error) {
+	nb, ok := bh.nodes[db]
+	if !ok {
+		return func() {}, nil
+	}
+
+	laneRelease := func() {}
+	if lane, ok := nb.lanes[GetLane(ctx)]; ok {
+		release, err := lane.acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		laneRelease = release
+	}
+	nodeRelease, err := nb.node.acquire(ctx)
+	if err != nil {
+		laneRelease()
+		return nil, err
+	}
+	return func() {
+		nodeRelease()
+		laneRelease()
+	}, nil
+}
+
+func newLimiter(lane string, cfg LaneConfig) *limiter {
+	if cfg.MaxWait <= 0 {
+		cfg.MaxWait = time.Second
+	}
+	l := &limiter{lane: lane, cfg: cfg}
+	if cfg.MaxConcurrent > 0 {
+		l.slots = make(chan struct{}, cfg.MaxConcurrent)
+	}
+	return l
+}
+
+type limiter struct {
+	lane    string
+	cfg     LaneConfig
+	slots   chan struct{}
+	waiting int64
+}
+
+func (l *limiter) acquire(ctx context.Context) (func(), error) {
+	if l.slots == nil {
+		return func() {}, nil
+	}
+	release := func() {
+		<-l.slots
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	waiting := atomic.AddInt64(&l.waiting, 1)
+	defer atomic.AddInt64(&l.waiting, -1)
+	if l.cfg.MaxWaiting > 0 && waiting > int64(l.cfg.MaxWaiting) {
+		return nil, &BulkheadError{Lane: l.lane, Full: true}
+	}
+
+	start := time.Now()
+	timer := time.NewTimer(l.cfg.MaxWait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		return nil, &BulkheadError{Lane: l.lane, Waited: time.Since(start)}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
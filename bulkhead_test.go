@@ -0,0 +1,118 @@
+package sqlxx
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBulkhead_NodeLimit(t *testing.T) {
+	db, _ := newFakeDB("master", "mysql")
+	adapter := NewWith(db)
+	adapter.db.Cluster.EnableBulkhead(BulkheadConfig{
+		LaneConfig: LaneConfig{MaxConcurrent: 1, MaxWait: 20 * time.Millisecond},
+	})
+	ctx := context.Background()
+
+	// the tx holds the only slot until it is committed
+	txDB, err := adapter.GetDB(ctx).Begin(ctx, nil)
+	require.NoError(t, err)
+	_, err = adapter.GetDB(ctx).ExecContext(ctx, "UPDATE users SET name = ?", "a")
+	var bhErr *BulkheadError
+	require.True(t, errors.As(err, &bhErr))
+	assert.ErrorIs(t, err, ErrBulkhead)
+	assert.Empty(t, bhErr.Lane)
+	assert.False(t, bhErr.Full)
+	assert.GreaterOrEqual(t, int64(bhErr.Waited), int64(20*time.Millisecond))
+
+	require.NoError(t, txDB.Commit(ctx))
+	_, err = adapter.GetDB(ctx).ExecContext(ctx, "UPDATE users SET name = ?", "a")
+	assert.NoError(t, err)
+}
+
+func TestBulkhead_LaneLimit(t *testing.T) {
+	db, _ := newFakeDB("master", "mysql")
+	adapter := NewWith(db)
+	adapter.db.Cluster.EnableBulkhead(BulkheadConfig{
+		Lanes: map[string]LaneConfig{
+			"report": {MaxConcurrent: 1, MaxWait: 10 * time.Millisecond},
+		},
+	})
+	ctx := context.Background()
+	reportCtx := WithLane(ctx, "report")
+
+	txDB, err := adapter.GetDB(reportCtx).Begin(reportCtx, nil)
+	require.NoError(t, err)
+	defer txDB.Rollback(ctx)
+
+	var name string
+	err = adapter.GetDB(reportCtx).GetContext(reportCtx, &name, "SELECT name FROM users")
+	var bhErr *BulkheadError
+	require.True(t, errors.As(err, &bhErr))
+	assert.Equal(t, "report", bhErr.Lane)
+
+	// the other lanes are only limited by the node
+	assert.NoError(t, adapter.GetDB(ctx).GetContext(ctx, &name, "SELECT name FROM users"))
+	otherCtx := WithLane(ctx, "api")
+	assert.NoError(t, adapter.GetDB(otherCtx).GetContext(otherCtx, &name, "SELECT name FROM users"))
+}
+
+func TestBulkhead_MaxWaiting(t *testing.T) {
+	db, _ := newFakeDB("master", "mysql")
+	adapter := NewWith(db)
+	bh := adapter.db.Cluster.EnableBulkhead(BulkheadConfig{
+		LaneConfig: LaneConfig{MaxConcurrent: 1, MaxWaiting: 1, MaxWait: time.Second},
+	})
+	ctx := context.Background()
+
+	txDB, err := adapter.GetDB(ctx).Begin(ctx, nil)
+	require.NoError(t, err)
+	waited := make(chan error)
+	go func() {
+		_, err := adapter.GetDB(ctx).ExecContext(ctx, "UPDATE users SET name = ?", "a")
+		waited <- err
+	}()
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&bh.nodes[db].node.waiting) == 1
+	}, time.Second, time.Millisecond)
+
+	// the wait queue is full, the query fails without waiting
+	start := time.Now()
+	_, err = adapter.GetDB(ctx).ExecContext(ctx, "UPDATE users SET name = ?", "b")
+	var bhErr *BulkheadError
+	require.True(t, errors.As(err, &bhErr))
+	assert.True(t, bhErr.Full)
+	assert.Less(t, int64(time.Since(start)), int64(500*time.Millisecond))
+
+	require.NoError(t, txDB.Commit(ctx))
+	assert.NoError(t, <-waited)
+}
+
+func TestBulkhead_ExecuteTx(t *testing.T) {
+	db, node := newFakeDB("master", "mysql")
+	adapter := NewWith(db)
+	adapter.db.Cluster.EnableBulkhead(BulkheadConfig{
+		LaneConfig: LaneConfig{MaxConcurrent: 1, MaxWait: 10 * time.Millisecond},
+	})
+	ctx := context.Background()
+
+	err := adapter.ExecuteTx(ctx, func(txCtx context.Context) error {
+		// the statements of the tx run on its slot
+		if _, err := adapter.GetDB(txCtx).ExecContext(txCtx, "UPDATE users SET name = ?", "a"); err != nil {
+			return err
+		}
+		_, err := adapter.GetDB(ctx).ExecContext(ctx, "UPDATE users SET name = ?", "b")
+		assert.ErrorIs(t, err, ErrBulkhead, "the slot is held until commit")
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"BEGIN", "UPDATE users SET name = ?", "COMMIT"}, node.Log())
+
+	_, err = adapter.GetDB(ctx).ExecContext(ctx, "UPDATE users SET name = ?", "b")
+	assert.NoError(t, err)
+}
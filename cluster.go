@@ -2,8 +2,12 @@ package sqlxx
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"io"
+	"reflect"
 	"sync"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
@@ -11,6 +15,7 @@ import (
 type (
 	MasterSlaveKey    struct{}
 	IsolationLevelKey struct{}
+	NodeFilterKey     struct{}
 )
 
 func WithSlave(ctx context.Context) context.Context {
@@ -31,47 +36,247 @@ func IsMaster(ctx context.Context) bool {
 	return ok && val == 2
 }
 
+// WithIsolation sets the isolation level of the tx begun with ctx when no
+// TxOptions is given.
+func WithIsolation(ctx context.Context, level sql.IsolationLevel) context.Context {
+	return context.WithValue(ctx, IsolationLevelKey{}, level)
+}
+
+func GetIsolation(ctx context.Context) (sql.IsolationLevel, bool) {
+	level, ok := ctx.Value(IsolationLevelKey{}).(sql.IsolationLevel)
+	return level, ok
+}
+
+// NodeFilter reports whether db is allowed to serve the request.
+type NodeFilter func(db *sqlx.DB) bool
+
+// WithNodeFilter narrows the nodes a Policy may return, filters are combined
+// with the ones already attached to ctx.
+func WithNodeFilter(ctx context.Context, filter NodeFilter) context.Context {
+	if prev, ok := ctx.Value(NodeFilterKey{}).(NodeFilter); ok {
+		next := filter
+		filter = func(db *sqlx.DB) bool {
+			return prev(db) && next(db)
+		}
+	}
+	return context.WithValue(ctx, NodeFilterKey{}, filter)
+}
+
+// NodeAllowed reports whether db passes the filters attached to ctx, custom
+// policies should use it to skip unavailable nodes.
+func NodeAllowed(ctx context.Context, db *sqlx.DB) bool {
+	filter, ok := ctx.Value(NodeFilterKey{}).(NodeFilter)
+	return !ok || filter(db)
+}
+
+var (
+	ErrNoDBPool      = errors.New("db pool is not set up")
+	ErrNoHealthyNode = errors.New("no healthy db node")
+)
+
 type Policy interface {
 	Get(ctx context.Context) (*sqlx.DB, error)
 }
 
+// Observer is notified with the outcome of every statement routed to db, policies
+// implementing it are notified by the cluster.
+type Observer interface {
+	Observe(db *sqlx.DB, err error, cost time.Duration)
+}
+
+// NodeLister is implemented by policies which can enumerate their dbs.
+type NodeLister interface {
+	Nodes() []*sqlx.DB
+}
+
 func NewRRCluster(masters []*sqlx.DB, slaves []*sqlx.DB) *Cluster {
+	return NewClusterWithPolicies(NewRoundRubinPolicy(masters), NewRoundRubinPolicy(slaves))
+}
+
+func NewClusterWithPolicies(masterPolicy, slavePolicy Policy) *Cluster {
 	return &Cluster{
-		masters: &RoundRubinPolicy{dbs: masters},
-		slaves:  &RoundRubinPolicy{dbs: masters},
+		masters: masterPolicy,
+		slaves:  slavePolicy,
 	}
 }
 
 type Cluster struct {
-	masters Policy
-	slaves  Policy
+	masters  Policy
+	slaves   Policy
+	lock     sync.RWMutex
+	health   *HealthChecker
+	lag      *LagMonitor
+	breaker  *CircuitBreaker
+	bulkhead *Bulkhead
+	counters clusterCounters
+	zones    map[*sqlx.DB]string
+}
+
+func (c *Cluster) GetDB(ctx context.Context) (*sqlx.DB, error) {
+	db, err := c.getDB(WithNodeFilter(ctx, c.allow))
+	if err != nil {
+		return nil, err
+	}
+	c.picked(db)
+	return db, nil
 }
 
-func (c Cluster) GetDB(ctx context.Context) (*sqlx.DB, error) {
+func (c *Cluster) getDB(ctx context.Context) (*sqlx.DB, error) {
 	if IsReadOnly(ctx) {
-		return c.slaves.Get(ctx)
+		if maxStaleness, ok := GetMaxStaleness(ctx); ok {
+			return c.getFreshSlave(ctx, maxStaleness)
+		}
+		return c.getSlave(ctx)
+	}
+	return c.masters.Get(ctx)
+}
+
+func (c *Cluster) getFreshSlave(ctx context.Context, maxStaleness time.Duration) (*sqlx.DB, error) {
+	c.lock.RLock()
+	lag := c.lag
+	c.lock.RUnlock()
+
+	if lag != nil {
+		freshCtx := WithNodeFilter(ctx, func(db *sqlx.DB) bool {
+			d, ok := lag.Lag(db)
+			return ok && d < maxStaleness
+		})
+		// any slave error, including an empty pool, falls back to the master
+		if db, err := c.getSlave(freshCtx); err == nil {
+			return db, nil
+		}
 	}
 	return c.masters.Get(ctx)
 }
 
-type RoundRubinPolicy struct {
-	dbs       []*sqlx.DB
-	lock      sync.Mutex
-	currIndex int
+// Masters returns the dbs of the master policy, it is empty if the policy is not a NodeLister.
+func (c *Cluster) Masters() []*sqlx.DB {
+	return listNodes(c.masters)
+}
+
+// Slaves returns the dbs of the slave policy, it is empty if the policy is not a NodeLister.
+func (c *Cluster) Slaves() []*sqlx.DB {
+	return listNodes(c.slaves)
 }
 
-func (po *RoundRubinPolicy) Get(ctx context.Context) (*sqlx.DB, error) {
-	po.lock.Lock()
-	defer po.lock.Unlock()
-	if len(po.dbs) == 0 {
-		return nil, errors.New("db pool is not set up")
+// Nodes returns the distinct dbs of the master and slave policies.
+func (c *Cluster) Nodes() []*sqlx.DB {
+	seen := make(map[*sqlx.DB]bool)
+	nodes := make([]*sqlx.DB, 0, 10)
+	for _, db := range append(c.Masters(), c.Slaves()...) {
+		if db == nil || seen[db] {
+			continue
+		}
+		seen[db] = true
+		nodes = append(nodes, db)
 	}
+	return nodes
+}
 
-	if po.currIndex >= len(po.dbs) {
-		po.currIndex = 0
+// Close stops the background workers of the cluster, the dbs are left open.
+func (c *Cluster) Close() error {
+	c.lock.Lock()
+	health, lag := c.health, c.lag
+	c.health, c.lag = nil, nil
+	c.lock.Unlock()
+
+	if health != nil {
+		health.Close()
+	}
+	if lag != nil {
+		lag.Close()
 	}
+	for _, policy := range c.policies() {
+		if closer, ok := policy.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+	return nil
+}
 
-	db := po.dbs[po.currIndex]
-	po.currIndex++
-	return db, nil
+// acquire takes a slot of db from the bulkhead, release must be called once the
+// query is done.
+func (c *Cluster) acquire(ctx context.Context, db *sqlx.DB) (func(), error) {
+	c.lock.RLock()
+	bulkhead := c.bulkhead
+	c.lock.RUnlock()
+
+	if bulkhead == nil {
+		return func() {}, nil
+	}
+	return bulkhead.acquire(ctx, db)
+}
+
+// observe reports the outcome of the statement db is picked for by GetDB, it
+// is a probe of a half-open breaker.
+func (c *Cluster) observe(db *sqlx.DB, err error, cost time.Duration) {
+	c.report(db, err, cost, true)
+}
+
+// observeTx reports the outcome of a statement of a tx or pinned conn, they
+// share the probe of the statement their node is picked for.
+func (c *Cluster) observeTx(db *sqlx.DB, err error, cost time.Duration) {
+	c.report(db, err, cost, false)
+}
+
+func (c *Cluster) report(db *sqlx.DB, err error, cost time.Duration, probe bool) {
+	if c == nil || db == nil {
+		return
+	}
+	c.lock.RLock()
+	breaker := c.breaker
+	c.lock.RUnlock()
+
+	c.counters.observe(db, err, cost)
+	if breaker != nil {
+		breaker.observe(db, err, cost, probe)
+	}
+	for _, policy := range c.policies() {
+		if observer, ok := policy.(Observer); ok {
+			observer.Observe(db, err, cost)
+		}
+	}
+}
+
+func (c *Cluster) policies() []Policy {
+	policies := make([]Policy, 0, 2)
+	if c.masters != nil {
+		policies = append(policies, c.masters)
+	}
+	if c.slaves != nil && !(reflect.TypeOf(c.slaves).Comparable() && c.masters == c.slaves) {
+		policies = append(policies, c.slaves)
+	}
+	return policies
+}
+
+func (c *Cluster) allow(db *sqlx.DB) bool {
+	c.lock.RLock()
+	health, breaker := c.health, c.breaker
+	c.lock.RUnlock()
+
+	if health != nil && !health.IsHealthy(db) {
+		return false
+	}
+	if breaker != nil && !breaker.allow(db) {
+		return false
+	}
+	return true
+}
+
+func (c *Cluster) picked(db *sqlx.DB) {
+	c.lock.RLock()
+	breaker := c.breaker
+	c.lock.RUnlock()
+
+	if breaker != nil {
+		breaker.picked(db)
+	}
+}
+
+func listNodes(policy Policy) []*sqlx.DB {
+	lister, ok := policy.(NodeLister)
+	if !ok {
+		return nil
+	}
+	return lister.Nodes()
 }
@@ -0,0 +1,41 @@
+package sqlxx
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCluster_FreshSlaveFallback(t *testing.T) {
+	master, _ := newFakeDB("master", "mysql")
+	ctx := WithMaxStaleness(WithSlave(context.Background()), time.Second)
+
+	// empty slave pool falls back to the master
+	c := NewClusterWithPolicies(NewRoundRubinPolicy([]*sqlx.DB{master}), NewRoundRubinPolicy(nil))
+	c.EnableLagProbe(LagProbeFunc(func(ctx context.Context, db *sqlx.DB) (time.Duration, error) {
+		return 0, nil
+	}), time.Hour)
+	defer c.Close()
+	db, err := c.GetDB(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, master, db)
+
+	// lagging slaves fall back to the master
+	slave, _ := newFakeDB("slave", "mysql")
+	c = NewRRCluster([]*sqlx.DB{master}, []*sqlx.DB{slave})
+	c.EnableLagProbe(LagProbeFunc(func(ctx context.Context, db *sqlx.DB) (time.Duration, error) {
+		return time.Minute, nil
+	}), time.Hour)
+	defer c.Close()
+	require.Eventually(t, func() bool {
+		_, ok := c.lag.Lag(slave)
+		return ok
+	}, time.Second, 10*time.Millisecond)
+	db, err = c.GetDB(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, master, db)
+}
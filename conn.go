@@ -0,0 +1,111 @@
+package sqlxx
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrNilConn  = errors.New("conn is nil")
+	ErrConnInTx = errors.New("conn already has a tx")
+)
+
+type (
+	// ConnKey is the context key of the pinned connection of the database named Name.
+	ConnKey struct {
+		Name string
+	}
+)
+
+// WithConn pins a connection of the node resolved for ctx, every statement and
+// tx of GetDB(connCtx) runs on it until fn returns. fn runs on the current
+// connection if ctx already has a tx or a pinned connection. A tx of
+// PropagationRequiresNew inside a tx of the connection runs on a pooled
+// connection.
+func (adapter *Sqlxx) WithConn(ctx context.Context, fn func(connCtx context.Context) error) error {
+	if adapter.HasTx(ctx) || adapter.getConn(ctx) != nil {
+		return fn(ctx)
+	}
+
+	connDB, err := adapter.db.PinConn(ctx)
+	if err != nil {
+		return err
+	}
+	defer connDB.ReleaseConn()
+	return fn(adapter.withConn(ctx, connDB))
+}
+
+func (adapter *Sqlxx) withConn(ctx context.Context, db *DB) context.Context {
+	return context.WithValue(ctx, ConnKey{Name: adapter.name}, db)
+}
+
+func (adapter *Sqlxx) getConn(ctx context.Context) *DB {
+	connDB, ok := ctx.Value(ConnKey{Name: adapter.name}).(*DB)
+	if ok && connDB != nil {
+		return connDB
+	}
+
+	return nil
+}
+
+// PinConn checks out a connection of the node picked for ctx, or of the node of
+// the tx, the returned DB runs every statement on it until ReleaseConn is called.
+func (db *DB) PinConn(ctx context.Context) (*DB, error) {
+	cluster, sqlxDB := db.txCluster, db.txNode
+	if sqlxDB == nil {
+		var err error
+		cluster, err = db.getCluster(ctx)
+		if err != nil {
+			return nil, err
+		}
+		sqlxDB, err = cluster.GetDB(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	release, err := cluster.acquire(ctx, sqlxDB)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	conn, err := sqlxDB.Connx(ctx)
+	cluster.observe(sqlxDB, err, time.Since(start))
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &DB{Conn: conn, txCluster: cluster, txNode: sqlxDB, txDone: release}, nil
+}
+
+// hasConnTx reports whether the pinned conn holds a tx.
+func (db *DB) hasConnTx() bool {
+	return atomic.LoadInt32(&db.connTx) == 1
+}
+
+// ReleaseConn returns the pinned connection to the pool.
+func (db *DB) ReleaseConn() error {
+	if db.Conn == nil {
+		return ErrNilConn
+	}
+	err := db.Conn.Close()
+	db.doneTx()
+	return err
+}
+
+// connExt completes sqlx.Conn as a sqlx.ExtContext.
+type connExt struct {
+	*sqlx.Conn
+	driverName string
+}
+
+func (conn connExt) DriverName() string {
+	return conn.driverName
+}
+
+func (conn connExt) BindNamed(query string, arg interface{}) (string, []interface{}, error) {
+	return sqlx.BindNamed(sqlx.BindType(conn.driverName), query, arg)
+}
@@ -0,0 +1,75 @@
+package sqlxx
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithConn_RequiresNew(t *testing.T) {
+	db, node := newFakeDB("master", "mysql")
+	adapter := NewWith(db)
+	ctx := context.Background()
+
+	err := adapter.WithConn(ctx, func(connCtx context.Context) error {
+		if _, err := adapter.GetDB(connCtx).ExecContext(connCtx, "pinned"); err != nil {
+			return err
+		}
+		return adapter.ExecuteTx(connCtx, func(txCtx context.Context) error {
+			if _, err := adapter.GetDB(txCtx).ExecContext(txCtx, "outer"); err != nil {
+				return err
+			}
+			// the pinned conn holds the outer tx, the new tx needs another conn
+			err := adapter.ExecuteTx(txCtx, func(innerCtx context.Context) error {
+				_, err := adapter.GetDB(innerCtx).ExecContext(innerCtx, "inner")
+				return err
+			}, WithPropagation(PropagationRequiresNew))
+			if err != nil {
+				return err
+			}
+			_, err = adapter.GetDB(txCtx).ExecContext(txCtx, "outer again")
+			return err
+		})
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"pinned", "BEGIN", "outer", "BEGIN", "inner", "COMMIT", "outer again", "COMMIT"}, node.Log())
+	conns := node.ConnLog()
+	pinned := conns[0]
+	assert.Equal(t, []int64{pinned, pinned, pinned}, []int64{conns[1], conns[2], conns[6]})
+	assert.NotEqual(t, pinned, conns[3])
+	assert.Equal(t, conns[3], conns[4])
+
+	// after the tx the pinned conn can begin again
+	err = adapter.WithConn(ctx, func(connCtx context.Context) error {
+		for i := 0; i < 2; i++ {
+			if err := adapter.ExecuteTx(connCtx, func(txCtx context.Context) error { return nil }); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestDB_BeginOnBusyConn(t *testing.T) {
+	db, _ := newFakeDB("master", "mysql")
+	adapter := NewWith(db)
+	ctx := context.Background()
+
+	connDB, err := adapter.db.PinConn(ctx)
+	require.NoError(t, err)
+	defer connDB.ReleaseConn()
+
+	txDB, err := connDB.Begin(ctx, nil)
+	require.NoError(t, err)
+	_, err = connDB.Begin(ctx, nil)
+	assert.Equal(t, ErrConnInTx, err)
+
+	require.NoError(t, txDB.Rollback(ctx))
+	txDB, err = connDB.Begin(ctx, nil)
+	require.NoError(t, err)
+	require.NoError(t, txDB.Commit(ctx))
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -11,35 +13,67 @@ import (
 	"github.com/vx416/sqlxx/logger"
 )
 
-var ErrNilTx = errors.New("tx is nil")
+var (
+	ErrNilTx = errors.New("tx is nil")
+	// ErrStaleObject is returned when the version of an optimistic locking update
+	// is changed by others
+	ErrStaleObject = errors.New("object is stale")
+)
+
+type clusterResolver interface {
+	GetCluster(ctx context.Context) (*Cluster, error)
+	Close() error
+}
 
 type DB struct {
-	Cluster *Cluster
-	Tx      *sqlx.Tx
+	Cluster  *Cluster
+	Tx       *sqlx.Tx
+	Conn     *sqlx.Conn
+	readOnly bool
+	resolver clusterResolver
+	// txCluster and txNode are the cluster and node the tx or conn is bound to
+	txCluster  *Cluster
+	txNode     *sqlx.DB
+	txDone     func()
+	savepoints int
+	hooks      *txHooks
+	// connTx is 1 while the pinned conn holds a tx
+	connTx int32
 }
 
 func (db *DB) GetRawDB(ctx context.Context) (*sql.DB, error) {
-	sqlxDB, err := db.Cluster.GetDB(ctx)
+	sqlxDB, err := db.getDB(ctx)
 	if err != nil {
 		return nil, err
 	}
 	return sqlxDB.DB, nil
 }
 
+// NamedQueryContext returns the rows without buffering them, so the bulkhead slot
+// of the node is released once the query returns rather than on rows.Close. The
+// rows are read outside the bulkhead limit while their connection stays checked
+// out of the pool, use SelectContext to read the rows inside the limit.
 func (db *DB) NamedQueryContext(ctx context.Context, query string, arg interface{}) (*sqlx.Rows, error) {
-	exec, err := db.getExec(ctx)
-	if err != nil {
-		return nil, err
-	}
-	return sqlx.NamedQueryContext(ctx, exec, query, arg)
+	var rows *sqlx.Rows
+	err := db.route(ctx, false, func(ext sqlx.ExtContext) error {
+		var err error
+		rows, err = sqlx.NamedQueryContext(ctx, SqlxxExtContext{ext}, query, arg)
+		return err
+	})
+	return rows, err
 }
 
 func (db *DB) NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error) {
-	exec, err := db.getExec(ctx)
-	if err != nil {
-		return nil, err
+	var res sql.Result
+	err := db.route(ctx, false, func(ext sqlx.ExtContext) error {
+		var err error
+		res, err = sqlx.NamedExecContext(ctx, SqlxxExtContext{ext}, query, arg)
+		return err
+	})
+	if err == nil && db.Tx == nil {
+		GetSession(ctx).MarkWrite()
 	}
-	return sqlx.NamedExecContext(ctx, exec, query, arg)
+	return res, err
 }
 
 func (db *DB) Select(ctx context.Context, dest interface{}, query builder.Builder) error {
@@ -52,20 +86,23 @@ func (db *DB) Select(ctx context.Context, dest interface{}, query builder.Builde
 
 func (db *DB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
 	var (
-		start  = time.Now()
-		err    error
-		driver sqlx.QueryerContext
+		start = time.Now()
+		err   error
 	)
 	defer func() {
 		cost := time.Since(start)
 		logger.Print(ctx, 0, err, cost, query, args...)
 	}()
 
-	driver, err = db.getQuery(ctx)
-	if err != nil {
+	if delay, ok := GetHedge(ctx); ok && db.Tx == nil && db.Conn == nil {
+		err = db.hedge(ctx, dest, delay, func(ctx context.Context, q sqlx.QueryerContext, dest interface{}) error {
+			return sqlx.SelectContext(ctx, q, dest, query, args...)
+		})
 		return err
 	}
-	err = sqlx.SelectContext(ctx, driver, dest, query, args...)
+	err = db.route(ctx, true, func(ext sqlx.ExtContext) error {
+		return sqlx.SelectContext(ctx, ext, dest, query, args...)
+	})
 	return err
 }
 
@@ -79,29 +116,51 @@ func (db *DB) Get(ctx context.Context, dest interface{}, query builder.Builder)
 
 func (db *DB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
 	var (
-		start  = time.Now()
-		err    error
-		driver sqlx.QueryerContext
+		start = time.Now()
+		err   error
 	)
 	defer func() {
 		cost := time.Since(start)
 		logger.Print(ctx, 0, err, cost, query, args...)
 	}()
 
-	driver, err = db.getQuery(ctx)
-	if err != nil {
+	if delay, ok := GetHedge(ctx); ok && db.Tx == nil && db.Conn == nil {
+		err = db.hedge(ctx, dest, delay, func(ctx context.Context, q sqlx.QueryerContext, dest interface{}) error {
+			return sqlx.GetContext(ctx, q, dest, query, args...)
+		})
 		return err
 	}
-	err = sqlx.GetContext(ctx, driver, dest, query, args...)
+	err = db.route(ctx, true, func(ext sqlx.ExtContext) error {
+		return sqlx.GetContext(ctx, ext, dest, query, args...)
+	})
 	return err
 }
 
+// Exec runs query, ErrStaleObject is returned if a versioned update matches no
+// row.
 func (db *DB) Exec(ctx context.Context, query builder.Builder) (sql.Result, error) {
 	queryS, args, err := query.Build()
 	if err != nil {
 		return nil, err
 	}
-	return db.ExecContext(ctx, queryS, args...)
+	res, err := db.ExecContext(ctx, queryS, args...)
+	if err != nil {
+		return res, err
+	}
+	if versioned, ok := query.(versionedBuilder); ok && versioned.Versioned() {
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return res, err
+		}
+		if rows == 0 {
+			return res, ErrStaleObject
+		}
+	}
+	return res, nil
+}
+
+type versionedBuilder interface {
+	Versioned() bool
 }
 
 func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
@@ -116,26 +175,17 @@ func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}
 		logger.Print(ctx, rows, err, cost, query, args...)
 	}()
 
-	if db.Tx != nil {
-		res, err = db.Tx.ExecContext(ctx, query, args...)
-		if err != nil {
-			return res, err
-		}
-		rows, err = res.RowsAffected()
-		if err != nil {
-			return res, err
-		}
-		return res, err
-	}
-
-	sqlxDB, err := db.Cluster.GetDB(ctx)
-	if err != nil {
-		return nil, err
-	}
-	res, err = sqlxDB.ExecContext(ctx, query, args...)
+	err = db.route(ctx, false, func(ext sqlx.ExtContext) error {
+		var err error
+		res, err = ext.ExecContext(ctx, query, args...)
+		return err
+	})
 	if err != nil {
 		return res, err
 	}
+	if db.Tx == nil {
+		GetSession(ctx).MarkWrite()
+	}
 	rows, err = res.RowsAffected()
 	if err != nil {
 		return res, err
@@ -143,23 +193,70 @@ func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}
 	return res, err
 }
 
+// Begin begins a tx, the isolation level and timeout attached to ctx are used
+// when txOpt is nil.
 func (db *DB) Begin(ctx context.Context, txOpt *sql.TxOptions) (*DB, error) {
 	if txOpt == nil {
 		txOpt = &sql.TxOptions{}
+		if level, ok := GetIsolation(ctx); ok {
+			txOpt.Isolation = level
+		}
 	}
 	if IsReadOnly(ctx) {
 		txOpt.ReadOnly = true
 	}
 
-	sqlxDB, err := db.Cluster.GetDB(ctx)
+	if db.Conn != nil {
+		return db.beginOnConn(ctx, txOpt)
+	}
+
+	cluster, err := db.getCluster(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sqlxDB, err := cluster.GetDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	release, err := cluster.acquire(ctx, sqlxDB)
 	if err != nil {
 		return nil, err
 	}
+	start := time.Now()
 	sqlxTx, err := sqlxDB.BeginTxx(ctx, txOpt)
+	cluster.observe(sqlxDB, err, time.Since(start))
+	if err != nil {
+		release()
+		return nil, err
+	}
+	stopWatch := watchLongTx(ctx)
+	txDone := func() {
+		stopWatch()
+		release()
+	}
+	return &DB{Tx: sqlxTx, Cluster: nil, readOnly: txOpt.ReadOnly, txCluster: cluster, txNode: sqlxDB, txDone: txDone}, nil
+}
+
+// beginOnConn begins a tx on the pinned conn, the conn keeps its bulkhead slot.
+// ErrConnInTx is returned if the conn already holds a tx, beginning another one
+// would commit it implicitly on MySQL.
+func (db *DB) beginOnConn(ctx context.Context, txOpt *sql.TxOptions) (*DB, error) {
+	if !atomic.CompareAndSwapInt32(&db.connTx, 0, 1) {
+		return nil, ErrConnInTx
+	}
+	start := time.Now()
+	sqlxTx, err := db.Conn.BeginTxx(ctx, txOpt)
+	db.txCluster.observeTx(db.txNode, err, time.Since(start))
 	if err != nil {
+		atomic.StoreInt32(&db.connTx, 0)
 		return nil, err
 	}
-	return &DB{Tx: sqlxTx, Cluster: nil}, nil
+	stopWatch := watchLongTx(ctx)
+	txDone := func() {
+		stopWatch()
+		atomic.StoreInt32(&db.connTx, 0)
+	}
+	return &DB{Tx: sqlxTx, readOnly: txOpt.ReadOnly, txCluster: db.txCluster, txNode: db.txNode, txDone: txDone}, nil
 }
 
 func (db *DB) Commit(ctx context.Context) error {
@@ -167,7 +264,15 @@ func (db *DB) Commit(ctx context.Context) error {
 		return ErrNilTx
 	}
 
-	return db.Tx.Commit()
+	start := time.Now()
+	err := db.Tx.Commit()
+	db.txCluster.observeTx(db.txNode, err, time.Since(start))
+	// the tx is done even if commit fails
+	db.doneTx()
+	if err == nil && !db.readOnly {
+		GetSession(ctx).MarkWrite()
+	}
+	return err
 }
 
 func (db *DB) Rollback(ctx context.Context) error {
@@ -175,38 +280,148 @@ func (db *DB) Rollback(ctx context.Context) error {
 		return ErrNilTx
 	}
 
-	return db.Tx.Rollback()
+	start := time.Now()
+	err := db.Tx.Rollback()
+	if !errors.Is(err, sql.ErrTxDone) {
+		db.txCluster.observeTx(db.txNode, err, time.Since(start))
+	}
+	db.doneTx()
+	return err
+}
+
+// Savepoint creates a savepoint in the tx and returns its name.
+func (db *DB) Savepoint(ctx context.Context) (string, error) {
+	if db.Tx == nil {
+		return "", ErrNilTx
+	}
+
+	db.savepoints++
+	name := fmt.Sprintf("sp_%d", db.savepoints)
+	create, _, _ := SavepointSQL(db.Dialect(), name)
+	if _, err := db.ExecContext(ctx, create); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func (db *DB) ReleaseSavepoint(ctx context.Context, name string) error {
+	if db.Tx == nil {
+		return ErrNilTx
+	}
+
+	_, release, _ := SavepointSQL(db.Dialect(), name)
+	if release == "" {
+		return nil
+	}
+	_, err := db.ExecContext(ctx, release)
+	return err
+}
+
+func (db *DB) RollbackToSavepoint(ctx context.Context, name string) error {
+	if db.Tx == nil {
+		return ErrNilTx
+	}
+
+	_, _, rollback := SavepointSQL(db.Dialect(), name)
+	_, err := db.ExecContext(ctx, rollback)
+	return err
+}
+
+// Rebind converts the ? bindvars of query to the bindvars of the tx or conn.
+func (db *DB) Rebind(query string) string {
+	if db.txNode == nil {
+		return query
+	}
+	return db.txNode.Rebind(query)
+}
+
+// Dialect returns the dialect of the tx or conn.
+func (db *DB) Dialect() Dialect {
+	if db.txNode == nil {
+		return UnknownDialect
+	}
+	return DialectOf(db.txNode.DriverName())
+}
+
+// doneTx releases what is held by the tx or conn once it is done.
+func (db *DB) doneTx() {
+	if db.txDone != nil {
+		db.txDone()
+		db.txDone = nil
+	}
 }
 
 func (db *DB) IsTx() bool {
 	return db.Tx != nil
 }
 
-func (db *DB) getExec(ctx context.Context) (sqlx.ExtContext, error) {
-	if db.Tx != nil {
-		return SqlxxExtContext{db.Tx}, nil
+func (db *DB) getCluster(ctx context.Context) (*Cluster, error) {
+	if db.resolver != nil {
+		return db.resolver.GetCluster(ctx)
 	}
+	if db.Cluster == nil {
+		return nil, ErrNoDBPool
+	}
+	return db.Cluster, nil
+}
 
-	sqlxDB, err := db.Cluster.GetDB(ctx)
+func (db *DB) getDB(ctx context.Context) (*sqlx.DB, error) {
+	if db.txNode != nil {
+		return db.txNode, nil
+	}
+	cluster, err := db.getCluster(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return SqlxxExtContext{sqlxDB}, nil
+	return cluster.GetDB(ctx)
 }
 
-func (db *DB) getQuery(ctx context.Context) (sqlx.QueryerContext, error) {
+// route runs fn on the tx, the conn or on the node picked for ctx, reads go to slave
+// unless ctx asks for master, the outcome of fn is reported to the cluster.
+func (db *DB) route(ctx context.Context, read bool, fn func(ext sqlx.ExtContext) error) error {
 	if db.Tx != nil {
-		return db.Tx, nil
+		start := time.Now()
+		err := fn(db.Tx)
+		db.txCluster.observeTx(db.txNode, err, time.Since(start))
+		return err
+	}
+	if db.Conn != nil {
+		start := time.Now()
+		err := fn(connExt{Conn: db.Conn, driverName: db.txNode.DriverName()})
+		db.txCluster.observeTx(db.txNode, err, time.Since(start))
+		return err
 	}
 
-	if !IsMaster(ctx) {
-		ctx = WithSlave(ctx)
+	cluster, err := db.getCluster(ctx)
+	if err != nil {
+		return err
+	}
+	nodeCtx := ctx
+	if read {
+		nodeCtx = readContext(ctx)
 	}
-	sqlxDB, err := db.Cluster.GetDB(ctx)
+	sqlxDB, err := cluster.GetDB(nodeCtx)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	release, err := cluster.acquire(ctx, sqlxDB)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	start := time.Now()
+	err = fn(sqlxDB)
+	cluster.observe(sqlxDB, err, time.Since(start))
+	return err
+}
+
+// readContext routes a read to slave unless ctx asks for master.
+func readContext(ctx context.Context) context.Context {
+	if IsMaster(ctx) || GetSession(ctx).StickToMaster() {
+		return ctx
 	}
-	return sqlxDB, nil
+	return WithSlave(ctx)
 }
 
 type SqlxxExtContext struct {
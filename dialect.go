@@ -0,0 +1,117 @@
+package sqlxx
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type Dialect string
+
+const (
+	UnknownDialect Dialect = ""
+	MySQL          Dialect = "mysql"
+	Postgres       Dialect = "postgres"
+	SQLite         Dialect = "sqlite"
+	SQLServer      Dialect = "sqlserver"
+)
+
+// DialectOf returns the dialect of a database/sql driver name.
+func DialectOf(driverName string) Dialect {
+	switch strings.ToLower(driverName) {
+	case "mysql", "nrmysql":
+		return MySQL
+	case "postgres", "pgx", "pq-timeouts", "cloudsqlpostgres", "nrpostgres", "cockroach":
+		return Postgres
+	case "sqlite3", "sqlite", "nrsqlite3":
+		return SQLite
+	case "sqlserver", "mssql", "azuresql":
+		return SQLServer
+	default:
+		return UnknownDialect
+	}
+}
+
+var mysqlErrorRegexp = regexp.MustCompile(`^Error (\d+)`)
+
+// MySQLErrorNumber returns the server error number of a go-sql-driver/mysql error.
+func MySQLErrorNumber(err error) (int, bool) {
+	for ; err != nil; err = errors.Unwrap(err) {
+		val := reflect.Indirect(reflect.ValueOf(err))
+		if val.Kind() == reflect.Struct {
+			number := val.FieldByName("Number")
+			if number.IsValid() && number.Kind() >= reflect.Uint && number.Kind() <= reflect.Uint64 {
+				return int(number.Uint()), true
+			}
+		}
+		if matches := mysqlErrorRegexp.FindStringSubmatch(err.Error()); len(matches) == 2 {
+			number, convErr := strconv.Atoi(matches[1])
+			return number, convErr == nil
+		}
+	}
+	return 0, false
+}
+
+// SQLState returns the SQLSTATE code of a lib/pq or pgx error.
+func SQLState(err error) (string, bool) {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if stater, ok := err.(interface{ SQLState() string }); ok {
+			return stater.SQLState(), true
+		}
+		val := reflect.Indirect(reflect.ValueOf(err))
+		if val.Kind() == reflect.Struct {
+			code := val.FieldByName("Code")
+			if code.IsValid() && code.Kind() == reflect.String && len(code.String()) == 5 {
+				return code.String(), true
+			}
+		}
+	}
+	return "", false
+}
+
+// IsRetryable reports whether err is a transient error of dialect, the whole
+// transaction can be retried after it, e.g. deadlock and serialization failure.
+func IsRetryable(dialect Dialect, err error) bool {
+	if err == nil {
+		return false
+	}
+
+	switch dialect {
+	case MySQL:
+		return isMySQLRetryable(err)
+	case Postgres:
+		return isPostgresRetryable(err)
+	case SQLite:
+		return isSQLiteRetryable(err)
+	default:
+		return isMySQLRetryable(err) || isPostgresRetryable(err) || isSQLiteRetryable(err)
+	}
+}
+
+func isMySQLRetryable(err error) bool {
+	number, ok := MySQLErrorNumber(err)
+	// 1213: ER_LOCK_DEADLOCK, 1205: ER_LOCK_WAIT_TIMEOUT
+	return ok && (number == 1213 || number == 1205)
+}
+
+func isPostgresRetryable(err error) bool {
+	state, ok := SQLState(err)
+	// 40001: serialization_failure, 40P01: deadlock_detected
+	return ok && (state == "40001" || state == "40P01")
+}
+
+func isSQLiteRetryable(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "database is locked") || strings.Contains(msg, "database table is locked")
+}
+
+// SavepointSQL returns the statements to create, release and roll back to the
+// savepoint name, release is empty when the dialect has no such statement.
+func SavepointSQL(dialect Dialect, name string) (create, release, rollback string) {
+	if dialect == SQLServer {
+		return "SAVE TRANSACTION " + name, "", "ROLLBACK TRANSACTION " + name
+	}
+	return "SAVEPOINT " + name, "RELEASE SAVEPOINT " + name, "ROLLBACK TO SAVEPOINT " + name
+}
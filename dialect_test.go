@@ -0,0 +1,144 @@
+package sqlxx
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mysqlError has the shape of go-sql-driver/mysql.MySQLError
+type mysqlError struct {
+	Number  uint16
+	Message string
+}
+
+func (e *mysqlError) Error() string {
+	return fmt.Sprintf("Error %d: %s", e.Number, e.Message)
+}
+
+// pqError has the shape of lib/pq.Error
+type pqError struct {
+	Code    pqErrorCode
+	Message string
+}
+
+type pqErrorCode string
+
+func (e *pqError) Error() string {
+	return "pq: " + e.Message
+}
+
+// pgxError has the shape of pgconn.PgError
+type pgxError struct {
+	code string
+}
+
+func (e *pgxError) Error() string {
+	return "ERROR: (SQLSTATE " + e.code + ")"
+}
+
+func (e *pgxError) SQLState() string {
+	return e.code
+}
+
+func TestDialectOf(t *testing.T) {
+	assert.Equal(t, MySQL, DialectOf("mysql"))
+	assert.Equal(t, Postgres, DialectOf("pgx"))
+	assert.Equal(t, SQLite, DialectOf("sqlite3"))
+	assert.Equal(t, SQLServer, DialectOf("mssql"))
+	assert.Equal(t, UnknownDialect, DialectOf("oracle"))
+	assert.Equal(t, UnknownDialect, DialectOf("ql"))
+}
+
+func TestMySQLErrorNumber(t *testing.T) {
+	testCases := []struct {
+		err    error
+		number int
+		ok     bool
+	}{
+		{&mysqlError{Number: 1213, Message: "Deadlock found"}, 1213, true},
+		{fmt.Errorf("update: %w", &mysqlError{Number: 1205}), 1205, true},
+		{errors.New("Error 1062: Duplicate entry"), 1062, true},
+		{fmt.Errorf("insert: %w", errors.New("Error 1062: Duplicate entry")), 1062, true},
+		{errors.New("pq: deadlock detected"), 0, false},
+		{&pqError{Code: "40P01"}, 0, false},
+		{nil, 0, false},
+	}
+	for _, tc := range testCases {
+		number, ok := MySQLErrorNumber(tc.err)
+		assert.Equal(t, tc.ok, ok, "%v", tc.err)
+		assert.Equal(t, tc.number, number, "%v", tc.err)
+	}
+}
+
+func TestSQLState(t *testing.T) {
+	testCases := []struct {
+		err   error
+		state string
+		ok    bool
+	}{
+		{&pqError{Code: "40001"}, "40001", true},
+		{fmt.Errorf("select: %w", &pqError{Code: "40P01"}), "40P01", true},
+		{&pgxError{code: "23505"}, "23505", true},
+		{fmt.Errorf("commit: %w", &pgxError{code: "40001"}), "40001", true},
+		{&mysqlError{Number: 1213}, "", false},
+		{errors.New("ERROR: deadlock detected"), "", false},
+		{nil, "", false},
+	}
+	for _, tc := range testCases {
+		state, ok := SQLState(tc.err)
+		assert.Equal(t, tc.ok, ok, "%v", tc.err)
+		assert.Equal(t, tc.state, state, "%v", tc.err)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	deadlock := &mysqlError{Number: 1213}
+	lockWait := fmt.Errorf("tx: %w", &mysqlError{Number: 1205})
+	duplicate := &mysqlError{Number: 1062}
+	serialization := &pgxError{code: "40001"}
+	pgDeadlock := &pqError{Code: "40P01"}
+	uniqueViolation := &pqError{Code: "23505"}
+	locked := errors.New("database is locked")
+
+	testCases := []struct {
+		dialect Dialect
+		err     error
+		want    bool
+	}{
+		{MySQL, deadlock, true},
+		{MySQL, lockWait, true},
+		{MySQL, duplicate, false},
+		{MySQL, serialization, false},
+		{Postgres, serialization, true},
+		{Postgres, pgDeadlock, true},
+		{Postgres, uniqueViolation, false},
+		{Postgres, deadlock, false},
+		{SQLite, locked, true},
+		{SQLite, errors.New("database table is locked: users"), true},
+		{SQLite, deadlock, false},
+		{UnknownDialect, deadlock, true},
+		{UnknownDialect, serialization, true},
+		{UnknownDialect, locked, true},
+		{UnknownDialect, uniqueViolation, false},
+		{MySQL, nil, false},
+		{UnknownDialect, nil, false},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.want, IsRetryable(tc.dialect, tc.err), "%s %v", tc.dialect, tc.err)
+	}
+}
+
+func TestSavepointSQL(t *testing.T) {
+	create, release, rollback := SavepointSQL(MySQL, "sp1")
+	assert.Equal(t, "SAVEPOINT sp1", create)
+	assert.Equal(t, "RELEASE SAVEPOINT sp1", release)
+	assert.Equal(t, "ROLLBACK TO SAVEPOINT sp1", rollback)
+
+	create, release, rollback = SavepointSQL(SQLServer, "sp1")
+	assert.Equal(t, "SAVE TRANSACTION sp1", create)
+	assert.Empty(t, release)
+	assert.Equal(t, "ROLLBACK TRANSACTION sp1", rollback)
+}
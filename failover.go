@@ -0,0 +1,306 @@
+package sqlxx
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// IsConnError reports whether err is a connection level error rather than an
+// error returned by the sql statement, canceled and timed out contexts are not.
+func IsConnError(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, connErr := range []error{
+		driver.ErrBadConn, sql.ErrConnDone, io.EOF, io.ErrUnexpectedEOF,
+		syscall.ECONNREFUSED, syscall.ECONNRESET, syscall.ECONNABORTED, syscall.EPIPE,
+	} {
+		if errors.Is(err, connErr) {
+			return true
+		}
+	}
+	// context.DeadlineExceeded implements net.Error
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"invalid connection", "bad connection", "connection refused", "connection reset", "broken pipe"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+type FailoverConfig struct {
+	// FailureThreshold is the consecutive connection errors of the active master before failing over, default is 3
+	FailureThreshold int
+	// PingTimeout is the timeout of checking a standby is writable before switching to it, default is 1s
+	PingTimeout time.Duration
+	// FailBack switches back to the primary once it is writable again, it is
+	// ignored without Fence
+	FailBack bool
+	// Fence stops the writes of the active standby before failing back, e.g. by
+	// setting it read only. The primary is only switched back to if Fence returns
+	// nil and the standby reports read only afterwards
+	Fence func(ctx context.Context, db *sqlx.DB) error
+	// FailBackInterval is the interval of pinging the primary after failing over, default is 10s
+	FailBackInterval time.Duration
+	// OnFailover is called after the active master is switched
+	OnFailover func(event FailoverEvent)
+}
+
+func (cfg *FailoverConfig) setDefault() {
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = 3
+	}
+	if cfg.PingTimeout <= 0 {
+		cfg.PingTimeout = time.Second
+	}
+	if cfg.FailBackInterval <= 0 {
+		cfg.FailBackInterval = 10 * time.Second
+	}
+}
+
+type FailoverEvent struct {
+	From     *sqlx.DB
+	To       *sqlx.DB
+	FailBack bool
+	Err      error
+	At       time.Time
+}
+
+var (
+	ErrNotWritable = errors.New("db is read only")
+	ErrNotFenced   = errors.New("db is not fenced")
+)
+
+// NewFailoverPolicy returns a master policy which sends every request to a single
+// active master, it switches to the next writable standby in order after
+// repeated connection errors of the active master. A standby is writable if
+// @@read_only is 0 on MySQL, or on Postgres if it is not in recovery and
+// default_transaction_read_only is off, other dialects are only pinged.
+//
+// The old master is not fenced, transactions and connections already opened on
+// it keep writing until they end. Use OnFailover to fence it, e.g. by setting it
+// read only, if writes must not be split between the masters. Fail back fences
+// the standby with Fence before switching back.
+func NewFailoverPolicy(primary *sqlx.DB, standbys []*sqlx.DB, cfg FailoverConfig) *FailoverPolicy {
+	cfg.setDefault()
+	return &FailoverPolicy{
+		cfg: cfg,
+		dbs: append([]*sqlx.DB{primary}, standbys...),
+	}
+}
+
+type FailoverPolicy struct {
+	cfg       FailoverConfig
+	dbs       []*sqlx.DB
+	lock      sync.RWMutex
+	active    int
+	failures  int
+	switching bool
+	closed    bool
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func (po *FailoverPolicy) Get(ctx context.Context) (*sqlx.DB, error) {
+	po.lock.RLock()
+	db := po.dbs[po.active]
+	po.lock.RUnlock()
+
+	if !NodeAllowed(ctx, db) {
+		return nil, ErrNoHealthyNode
+	}
+	return db, nil
+}
+
+// Active returns the current master.
+func (po *FailoverPolicy) Active() *sqlx.DB {
+	po.lock.RLock()
+	defer po.lock.RUnlock()
+	return po.dbs[po.active]
+}
+
+func (po *FailoverPolicy) Nodes() []*sqlx.DB {
+	return copyNodes(po.dbs)
+}
+
+func (po *FailoverPolicy) Observe(db *sqlx.DB, err error, cost time.Duration) {
+	po.lock.Lock()
+	if db != po.dbs[po.active] || po.switching || po.closed {
+		po.lock.Unlock()
+		return
+	}
+	if !IsConnError(err) {
+		if err == nil {
+			po.failures = 0
+		}
+		po.lock.Unlock()
+		return
+	}
+	po.failures++
+	if po.failures < po.cfg.FailureThreshold {
+		po.lock.Unlock()
+		return
+	}
+	po.switching = true
+	from := po.active
+	po.lock.Unlock()
+
+	go po.failover(from, err)
+}
+
+// Close stops the fail back worker.
+func (po *FailoverPolicy) Close() error {
+	po.lock.Lock()
+	po.closed = true
+	stop, done := po.stop, po.done
+	po.stop, po.done = nil, nil
+	po.lock.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	return nil
+}
+
+func (po *FailoverPolicy) failover(from int, cause error) {
+	to := -1
+	for i := 1; i < len(po.dbs); i++ {
+		idx := (from + i) % len(po.dbs)
+		if po.writable(po.dbs[idx]) == nil {
+			to = idx
+			break
+		}
+	}
+
+	po.lock.Lock()
+	po.switching = false
+	po.failures = 0
+	if to < 0 || po.closed {
+		po.lock.Unlock()
+		return
+	}
+	po.active = to
+	startFailBack := po.cfg.FailBack && po.cfg.Fence != nil && to != 0 && po.stop == nil
+	if startFailBack {
+		po.stop, po.done = make(chan struct{}), make(chan struct{})
+		go po.failBack(po.stop, po.done)
+	}
+	po.lock.Unlock()
+
+	po.emit(FailoverEvent{From: po.dbs[from], To: po.dbs[to], Err: cause, At: time.Now()})
+}
+
+func (po *FailoverPolicy) failBack(stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(po.cfg.FailBackInterval)
+	defer ticker.Stop()
+
+	successes := 0
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if po.writable(po.dbs[0]) != nil {
+			successes = 0
+			continue
+		}
+		successes++
+		if successes < po.cfg.FailureThreshold {
+			continue
+		}
+
+		from := po.Active()
+		if from != po.dbs[0] && po.fence(from) != nil {
+			continue
+		}
+		po.lock.Lock()
+		if po.switching || po.closed || po.dbs[po.active] != from {
+			po.lock.Unlock()
+			continue
+		}
+		po.active = 0
+		po.failures = 0
+		po.stop, po.done = nil, nil
+		po.lock.Unlock()
+
+		if from == po.dbs[0] {
+			return
+		}
+		po.emit(FailoverEvent{From: from, To: po.dbs[0], FailBack: true, At: time.Now()})
+		return
+	}
+}
+
+// writable pings db and checks it is not a read only replica.
+func (po *FailoverPolicy) writable(db *sqlx.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), po.cfg.PingTimeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		return err
+	}
+
+	var query string
+	switch DialectOf(db.DriverName()) {
+	case MySQL:
+		query = "SELECT @@read_only"
+	case Postgres:
+		// a promoted standby never returns to recovery, it is fenced by
+		// default_transaction_read_only
+		query = "SELECT pg_is_in_recovery() OR current_setting('default_transaction_read_only') = 'on'"
+	default:
+		return nil
+	}
+	var readOnly bool
+	if err := db.GetContext(ctx, &readOnly, query); err != nil {
+		return err
+	}
+	if readOnly {
+		return ErrNotWritable
+	}
+	return nil
+}
+
+// fence stops the writes of db with Fence and checks db is read only, dialects
+// not checked by writable are trusted to be fenced by Fence.
+func (po *FailoverPolicy) fence(db *sqlx.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), po.cfg.PingTimeout)
+	defer cancel()
+	if err := po.cfg.Fence(ctx, db); err != nil {
+		return err
+	}
+	switch DialectOf(db.DriverName()) {
+	case MySQL, Postgres:
+		if err := po.writable(db); !errors.Is(err, ErrNotWritable) {
+			return ErrNotFenced
+		}
+	}
+	return nil
+}
+
+func (po *FailoverPolicy) emit(event FailoverEvent) {
+	if po.cfg.OnFailover != nil {
+		po.cfg.OnFailover(event)
+	}
+}
@@ -0,0 +1,181 @@
+package sqlxx
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsConnError(t *testing.T) {
+	testCases := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{driver.ErrBadConn, true},
+		{io.EOF, true},
+		{fmt.Errorf("query: %w", syscall.ECONNRESET), true},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{&net.DNSError{Err: "no such host", IsTimeout: true}, true},
+		{errors.New("invalid connection"), true},
+		{errors.New("write: Broken pipe"), true},
+		{context.Canceled, false},
+		{context.DeadlineExceeded, false},
+		{fmt.Errorf("query: %w", context.DeadlineExceeded), false},
+		{errors.New("Error 1062: Duplicate entry"), false},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.want, IsConnError(tc.err), "%v", tc.err)
+	}
+}
+
+func newReadOnlyFakeDB(name string, readOnly bool) (*sqlx.DB, *fakeNode) {
+	db, node := newFakeDB(name, "mysql")
+	node.Rows = func(query string) ([]string, [][]driver.Value) {
+		return []string{"@@read_only"}, [][]driver.Value{{readOnly}}
+	}
+	return db, node
+}
+
+func TestFailoverPolicy_SkipReadOnlyStandby(t *testing.T) {
+	primary, primaryNode := newReadOnlyFakeDB("primary", false)
+	replica, replicaNode := newReadOnlyFakeDB("replica", true)
+	standby, _ := newReadOnlyFakeDB("standby", false)
+
+	events := make(chan FailoverEvent, 1)
+	po := NewFailoverPolicy(primary, []*sqlx.DB{replica, standby}, FailoverConfig{
+		FailureThreshold: 2,
+		OnFailover: func(event FailoverEvent) {
+			events <- event
+		},
+	})
+	defer po.Close()
+
+	primaryNode.SetErr(driver.ErrBadConn)
+	po.Observe(primary, context.DeadlineExceeded, time.Millisecond)
+	po.Observe(primary, driver.ErrBadConn, time.Millisecond)
+	assert.Same(t, primary, po.Active(), "below the threshold")
+	po.Observe(primary, driver.ErrBadConn, time.Millisecond)
+
+	select {
+	case event := <-events:
+		assert.Same(t, primary, event.From)
+		assert.Same(t, standby, event.To)
+	case <-time.After(time.Second):
+		require.FailNow(t, "no failover")
+	}
+	assert.Same(t, standby, po.Active())
+	assert.Contains(t, replicaNode.Log(), "SELECT @@read_only")
+
+	db, err := po.Get(context.Background())
+	require.NoError(t, err)
+	assert.Same(t, standby, db)
+}
+
+func TestFailoverPolicy_NoWritableStandby(t *testing.T) {
+	primary, _ := newReadOnlyFakeDB("primary", false)
+	replica, _ := newReadOnlyFakeDB("replica", true)
+	down, downNode := newReadOnlyFakeDB("down", false)
+	downNode.SetErr(driver.ErrBadConn)
+
+	po := NewFailoverPolicy(primary, []*sqlx.DB{replica, down}, FailoverConfig{FailureThreshold: 1})
+	defer po.Close()
+
+	po.Observe(primary, driver.ErrBadConn, time.Millisecond)
+	require.Eventually(t, func() bool {
+		po.lock.RLock()
+		defer po.lock.RUnlock()
+		return !po.switching
+	}, time.Second, 5*time.Millisecond)
+	assert.Same(t, primary, po.Active())
+}
+
+func TestFailoverPolicy_FailBackFence(t *testing.T) {
+	primary, primaryNode := newReadOnlyFakeDB("primary", false)
+	standby, standbyNode := newFakeDB("standby", "mysql")
+	var readOnly int32
+	standbyNode.Rows = func(query string) ([]string, [][]driver.Value) {
+		return []string{"@@read_only"}, [][]driver.Value{{atomic.LoadInt32(&readOnly) == 1}}
+	}
+
+	var fences int32
+	events := make(chan FailoverEvent, 2)
+	po := NewFailoverPolicy(primary, []*sqlx.DB{standby}, FailoverConfig{
+		FailureThreshold: 1,
+		FailBack:         true,
+		FailBackInterval: time.Millisecond,
+		Fence: func(ctx context.Context, db *sqlx.DB) error {
+			assert.Same(t, standby, db)
+			// the first fence fails, the second does not make the standby read only
+			switch atomic.AddInt32(&fences, 1) {
+			case 1:
+				return errors.New("fence failed")
+			case 2:
+				return nil
+			}
+			atomic.StoreInt32(&readOnly, 1)
+			return nil
+		},
+		OnFailover: func(event FailoverEvent) {
+			events <- event
+		},
+	})
+	defer po.Close()
+
+	primaryNode.SetErr(driver.ErrBadConn)
+	po.Observe(primary, driver.ErrBadConn, time.Millisecond)
+	select {
+	case event := <-events:
+		assert.Same(t, standby, event.To)
+	case <-time.After(time.Second):
+		require.FailNow(t, "no failover")
+	}
+	primaryNode.SetErr(nil)
+
+	select {
+	case event := <-events:
+		assert.True(t, event.FailBack)
+		assert.Same(t, standby, event.From)
+		assert.Same(t, primary, event.To)
+	case <-time.After(time.Second):
+		require.FailNow(t, "no fail back")
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&fences))
+	assert.Same(t, primary, po.Active())
+}
+
+func TestFailoverPolicy_FailBackWithoutFence(t *testing.T) {
+	primary, primaryNode := newReadOnlyFakeDB("primary", false)
+	standby, _ := newReadOnlyFakeDB("standby", false)
+
+	po := NewFailoverPolicy(primary, []*sqlx.DB{standby}, FailoverConfig{
+		FailureThreshold: 1,
+		FailBack:         true,
+		FailBackInterval: time.Millisecond,
+	})
+	defer po.Close()
+
+	primaryNode.SetErr(driver.ErrBadConn)
+	po.Observe(primary, driver.ErrBadConn, time.Millisecond)
+	require.Eventually(t, func() bool {
+		return po.Active() == standby
+	}, time.Second, time.Millisecond)
+	primaryNode.SetErr(nil)
+
+	time.Sleep(20 * time.Millisecond)
+	assert.Same(t, standby, po.Active())
+	po.lock.RLock()
+	defer po.lock.RUnlock()
+	assert.Nil(t, po.stop)
+}
@@ -0,0 +1,12 @@
+package sqlxx
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/vx416/sqlxx/internal/fakedb"
+)
+
+type fakeNode = fakedb.Node
+
+func newFakeDB(name, driverName string) (*sqlx.DB, *fakeNode) {
+	return fakedb.New(name, driverName)
+}
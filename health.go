@@ -0,0 +1,167 @@
+package sqlxx
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type HealthCheckConfig struct {
+	// Interval between two probe rounds, default is 5s
+	Interval time.Duration
+	// Timeout of a single ping, default is 1s
+	Timeout time.Duration
+	// FailureThreshold is the consecutive failed pings before a node is ejected, default is 3
+	FailureThreshold int
+	// SuccessThreshold is the consecutive successful pings before an ejected node is re-admitted, default is 2
+	SuccessThreshold int
+	// OnStateChange is called when a node is ejected or re-admitted
+	OnStateChange func(status NodeStatus)
+}
+
+func (cfg *HealthCheckConfig) setDefault() {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 5 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 1 * time.Second
+	}
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = 3
+	}
+	if cfg.SuccessThreshold <= 0 {
+		cfg.SuccessThreshold = 2
+	}
+}
+
+type NodeStatus struct {
+	DB                   *sqlx.DB
+	Healthy              bool
+	ConsecutiveFailures  int
+	ConsecutiveSuccesses int
+	LastError            error
+	LastCheckedAt        time.Time
+}
+
+// EnableHealthCheck starts a background prober which pings every node of the
+// cluster, ejected nodes are skipped by the cluster policies until they recover.
+func (c *Cluster) EnableHealthCheck(cfg HealthCheckConfig) *HealthChecker {
+	cfg.setDefault()
+	hc := &HealthChecker{
+		cfg:    cfg,
+		nodes:  c.Nodes(),
+		status: make(map[*sqlx.DB]*NodeStatus),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	for _, db := range hc.nodes {
+		hc.status[db] = &NodeStatus{DB: db, Healthy: true}
+	}
+
+	c.lock.Lock()
+	prev := c.health
+	c.health = hc
+	c.lock.Unlock()
+	if prev != nil {
+		prev.Close()
+	}
+
+	go hc.run()
+	return hc
+}
+
+type HealthChecker struct {
+	cfg    HealthCheckConfig
+	nodes  []*sqlx.DB
+	lock   sync.RWMutex
+	status map[*sqlx.DB]*NodeStatus
+	stop   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (hc *HealthChecker) IsHealthy(db *sqlx.DB) bool {
+	hc.lock.RLock()
+	defer hc.lock.RUnlock()
+	status, ok := hc.status[db]
+	return !ok || status.Healthy
+}
+
+// Status returns a snapshot of every probed node.
+func (hc *HealthChecker) Status() []NodeStatus {
+	hc.lock.RLock()
+	defer hc.lock.RUnlock()
+	res := make([]NodeStatus, 0, len(hc.nodes))
+	for _, db := range hc.nodes {
+		res = append(res, *hc.status[db])
+	}
+	return res
+}
+
+func (hc *HealthChecker) Close() error {
+	hc.once.Do(func() {
+		close(hc.stop)
+	})
+	<-hc.done
+	return nil
+}
+
+func (hc *HealthChecker) run() {
+	defer close(hc.done)
+	ticker := time.NewTicker(hc.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		hc.probe()
+		select {
+		case <-hc.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (hc *HealthChecker) probe() {
+	wg := sync.WaitGroup{}
+	for _, db := range hc.nodes {
+		wg.Add(1)
+		go func(db *sqlx.DB) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), hc.cfg.Timeout)
+			defer cancel()
+			hc.report(db, db.PingContext(ctx))
+		}(db)
+	}
+	wg.Wait()
+}
+
+func (hc *HealthChecker) report(db *sqlx.DB, err error) {
+	hc.lock.Lock()
+	status := hc.status[db]
+	status.LastCheckedAt = time.Now()
+	status.LastError = err
+	changed := false
+	if err != nil {
+		status.ConsecutiveFailures++
+		status.ConsecutiveSuccesses = 0
+		if status.Healthy && status.ConsecutiveFailures >= hc.cfg.FailureThreshold {
+			status.Healthy = false
+			changed = true
+		}
+	} else {
+		status.ConsecutiveSuccesses++
+		status.ConsecutiveFailures = 0
+		if !status.Healthy && status.ConsecutiveSuccesses >= hc.cfg.SuccessThreshold {
+			status.Healthy = true
+			changed = true
+		}
+	}
+	snapshot := *status
+	hc.lock.Unlock()
+
+	if changed && hc.cfg.OnStateChange != nil {
+		hc.cfg.OnStateChange(snapshot)
+	}
+}
@@ -0,0 +1,82 @@
+package sqlxx
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthChecker(t *testing.T) {
+	master, _ := newFakeDB("master", "mysql")
+	down, downNode := newFakeDB("down", "mysql")
+	up, _ := newFakeDB("up", "mysql")
+	downNode.SetErr(driver.ErrBadConn)
+	adapter := NewWithCluster([]*sqlx.DB{master}, []*sqlx.DB{down, up})
+	defer adapter.Close()
+
+	changes := make(chan NodeStatus, 2)
+	hc := adapter.db.Cluster.EnableHealthCheck(HealthCheckConfig{
+		// the rounds after the first one are run by the test
+		Interval:         time.Hour,
+		FailureThreshold: 2,
+		SuccessThreshold: 2,
+		OnStateChange: func(status NodeStatus) {
+			changes <- status
+		},
+	})
+	require.Eventually(t, func() bool {
+		for _, status := range hc.Status() {
+			if status.LastCheckedAt.IsZero() {
+				return false
+			}
+		}
+		return true
+	}, time.Second, time.Millisecond)
+	assert.True(t, hc.IsHealthy(down), "below the failure threshold")
+	assert.Empty(t, changes)
+
+	hc.probe()
+	assert.False(t, hc.IsHealthy(down))
+	status := <-changes
+	assert.Same(t, down, status.DB)
+	assert.False(t, status.Healthy)
+	assert.Equal(t, 2, status.ConsecutiveFailures)
+	assert.ErrorIs(t, status.LastError, driver.ErrBadConn)
+
+	// the ejected node is skipped by the policy
+	ctx := WithSlave(context.Background())
+	for i := 0; i < 4; i++ {
+		db, err := adapter.db.Cluster.GetDB(ctx)
+		require.NoError(t, err)
+		assert.Same(t, up, db)
+	}
+
+	downNode.SetErr(nil)
+	hc.probe()
+	assert.False(t, hc.IsHealthy(down), "below the success threshold")
+	hc.probe()
+	assert.True(t, hc.IsHealthy(down))
+	status = <-changes
+	assert.Same(t, down, status.DB)
+	assert.True(t, status.Healthy)
+	assert.Empty(t, changes)
+}
+
+func TestHealthChecker_Close(t *testing.T) {
+	db, _ := newFakeDB("master", "mysql")
+	adapter := NewWith(db)
+	hc := adapter.db.Cluster.EnableHealthCheck(HealthCheckConfig{Interval: time.Millisecond})
+
+	require.NoError(t, adapter.Close())
+	select {
+	case <-hc.done:
+	default:
+		require.FailNow(t, "the prober is still running")
+	}
+	assert.Nil(t, adapter.db.Cluster.health)
+}
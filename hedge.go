@@ -0,0 +1,110 @@
+package sqlxx
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type HedgeKey struct{}
+
+// WithHedge sends the reads of ctx to a second slave if the first one has not
+// answered within delay, the first result wins and the other query is cancelled.
+func WithHedge(ctx context.Context, delay time.Duration) context.Context {
+	return context.WithValue(ctx, HedgeKey{}, delay)
+}
+
+func GetHedge(ctx context.Context) (time.Duration, bool) {
+	delay, ok := ctx.Value(HedgeKey{}).(time.Duration)
+	return delay, ok
+}
+
+type hedgeResult struct {
+	val reflect.Value
+	err error
+}
+
+// hedge runs fn on up to two distinct nodes, every attempt scans into its own
+// value and only the winner is copied into dest.
+func (db *DB) hedge(ctx context.Context, dest interface{}, delay time.Duration, fn func(ctx context.Context, q sqlx.QueryerContext, dest interface{}) error) error {
+	destVal := reflect.ValueOf(dest)
+	if destVal.Kind() != reflect.Ptr || destVal.IsNil() {
+		return errors.New("dest should be non-nil pointer")
+	}
+	cluster, err := db.getCluster(ctx)
+	if err != nil {
+		return err
+	}
+	nodeCtx := readContext(ctx)
+	first, err := cluster.GetDB(nodeCtx)
+	if err != nil {
+		return err
+	}
+
+	hedgeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan hedgeResult, 2)
+	run := func(node *sqlx.DB) {
+		go func() {
+			val := reflect.New(destVal.Elem().Type())
+			release, err := cluster.acquire(hedgeCtx, node)
+			if err != nil {
+				results <- hedgeResult{val: val, err: err}
+				return
+			}
+			defer release()
+			start := time.Now()
+			err = fn(hedgeCtx, node, val.Interface())
+			if hedgeCtx.Err() == nil || err == nil {
+				cluster.observe(node, err, time.Since(start))
+			}
+			results <- hedgeResult{val: val, err: err}
+		}()
+	}
+
+	run(first)
+	pending, hedged := 1, false
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	hedgeNow := func() {
+		hedged = true
+		second, err := cluster.GetDB(WithNodeFilter(nodeCtx, func(node *sqlx.DB) bool {
+			return node != first
+		}))
+		if err == nil {
+			run(second)
+			pending++
+		}
+	}
+
+	var firstErr error
+	for {
+		select {
+		case <-timer.C:
+			if !hedged {
+				hedgeNow()
+			}
+		case res := <-results:
+			pending--
+			// connection and bulkhead errors give the other attempt a chance, the others are results
+			if res.err == nil || !(IsConnError(res.err) || errors.Is(res.err, ErrBulkhead)) {
+				if res.err == nil {
+					destVal.Elem().Set(res.val.Elem())
+				}
+				return res.err
+			}
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			if !hedged {
+				hedgeNow()
+			}
+			if pending == 0 {
+				return firstErr
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package sqlxx
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newHedgeCluster(slaves ...*sqlx.DB) *Sqlxx {
+	master, _ := newFakeDB("master", "mysql")
+	return NewWithCluster([]*sqlx.DB{master}, slaves)
+}
+
+func TestHedge_SlowReplica(t *testing.T) {
+	slow, slowNode := newFakeDB("slow", "mysql")
+	slowNode.Delay = time.Second
+	fast, fastNode := newFakeDB("fast", "mysql")
+	adapter := newHedgeCluster(slow, fast)
+	ctx := WithHedge(context.Background(), 10*time.Millisecond)
+
+	start := time.Now()
+	var name string
+	require.NoError(t, adapter.GetDB(ctx).GetContext(ctx, &name, "SELECT name FROM users"))
+	assert.Equal(t, "fast", name, "the second replica wins")
+	assert.Less(t, int64(time.Since(start)), int64(500*time.Millisecond), "the slow query is cancelled")
+	assert.Equal(t, []string{"SELECT name FROM users"}, slowNode.Log())
+	assert.Equal(t, []string{"SELECT name FROM users"}, fastNode.Log())
+
+	var names []string
+	require.NoError(t, adapter.GetDB(ctx).SelectContext(ctx, &names, "SELECT name FROM users"))
+	assert.Equal(t, []string{"fast"}, names)
+}
+
+func TestHedge_ConnError(t *testing.T) {
+	down, downNode := newFakeDB("down", "mysql")
+	downNode.SetErr(driver.ErrBadConn)
+	up, _ := newFakeDB("up", "mysql")
+	adapter := newHedgeCluster(down, up)
+	ctx := WithHedge(context.Background(), time.Second)
+
+	// the second replica is tried at once instead of after the delay
+	start := time.Now()
+	var name string
+	require.NoError(t, adapter.GetDB(ctx).GetContext(ctx, &name, "SELECT name FROM users"))
+	assert.Equal(t, "up", name)
+	assert.Less(t, int64(time.Since(start)), int64(500*time.Millisecond))
+}
+
+func TestHedge_SingleReplica(t *testing.T) {
+	slave, node := newFakeDB("slave", "mysql")
+	node.Delay = 30 * time.Millisecond
+	adapter := newHedgeCluster(slave)
+	ctx := WithHedge(context.Background(), time.Millisecond)
+
+	var name string
+	require.NoError(t, adapter.GetDB(ctx).GetContext(ctx, &name, "SELECT name FROM users"))
+	assert.Equal(t, "slave", name)
+	assert.Len(t, node.Log(), 1)
+
+	node.SetErr(driver.ErrBadConn)
+	assert.ErrorIs(t, adapter.GetDB(ctx).GetContext(ctx, &name, "SELECT name FROM users"), driver.ErrBadConn)
+}
@@ -0,0 +1,107 @@
+package sqlxx
+
+import (
+	"context"
+	"sync"
+)
+
+// AfterCommit registers fn to run after the tx of the unnamed database in ctx
+// is committed, use Sqlxx.AfterCommit for the databases of a Registry.
+func AfterCommit(ctx context.Context, fn func(ctx context.Context)) {
+	addAfterCommit(ctx, "", fn)
+}
+
+// AfterRollback registers fn to run after the tx of the unnamed database in ctx
+// is rolled back, use Sqlxx.AfterRollback for the databases of a Registry.
+func AfterRollback(ctx context.Context, fn func(ctx context.Context)) {
+	addAfterRollback(ctx, "", fn)
+}
+
+// AfterCommit registers fn to run after the tx of adapter in ctx is committed,
+// fn runs at once if ctx has no tx of adapter. Hooks of a nested or joined tx
+// wait for the outermost tx.
+func (adapter *Sqlxx) AfterCommit(ctx context.Context, fn func(ctx context.Context)) {
+	addAfterCommit(ctx, adapter.name, fn)
+}
+
+// AfterRollback registers fn to run after the tx of adapter in ctx is rolled
+// back, fn is dropped if ctx has no tx of adapter.
+func (adapter *Sqlxx) AfterRollback(ctx context.Context, fn func(ctx context.Context)) {
+	addAfterRollback(ctx, adapter.name, fn)
+}
+
+func addAfterCommit(ctx context.Context, name string, fn func(ctx context.Context)) {
+	hooks := getTxHooks(ctx, name)
+	if hooks == nil {
+		fn(ctx)
+		return
+	}
+	hooks.lock.Lock()
+	hooks.afterCommit = append(hooks.afterCommit, fn)
+	hooks.lock.Unlock()
+}
+
+func addAfterRollback(ctx context.Context, name string, fn func(ctx context.Context)) {
+	hooks := getTxHooks(ctx, name)
+	if hooks == nil {
+		return
+	}
+	hooks.lock.Lock()
+	hooks.afterRollback = append(hooks.afterRollback, fn)
+	hooks.lock.Unlock()
+}
+
+// getTxHooks returns the hooks of the tx of the database named name in ctx.
+func getTxHooks(ctx context.Context, name string) *txHooks {
+	txDB, ok := ctx.Value(TxKey{Name: name}).(*DB)
+	if !ok || txDB == nil {
+		return nil
+	}
+	return txDB.hooks
+}
+
+type txHooks struct {
+	lock          sync.Mutex
+	afterCommit   []func(ctx context.Context)
+	afterRollback []func(ctx context.Context)
+}
+
+// mark returns the number of after commit hooks, truncate drops the hooks
+// registered after mark when a savepoint is rolled back.
+func (hooks *txHooks) mark() int {
+	if hooks == nil {
+		return 0
+	}
+	hooks.lock.Lock()
+	defer hooks.lock.Unlock()
+	return len(hooks.afterCommit)
+}
+
+func (hooks *txHooks) truncate(mark int) {
+	if hooks == nil {
+		return
+	}
+	hooks.lock.Lock()
+	defer hooks.lock.Unlock()
+	if mark < len(hooks.afterCommit) {
+		hooks.afterCommit = hooks.afterCommit[:mark]
+	}
+}
+
+func (hooks *txHooks) committed(ctx context.Context) {
+	hooks.lock.Lock()
+	fns := hooks.afterCommit
+	hooks.lock.Unlock()
+	for _, fn := range fns {
+		fn(ctx)
+	}
+}
+
+func (hooks *txHooks) rolledBack(ctx context.Context) {
+	hooks.lock.Lock()
+	fns := hooks.afterRollback
+	hooks.lock.Unlock()
+	for _, fn := range fns {
+		fn(ctx)
+	}
+}
@@ -0,0 +1,108 @@
+package sqlxx
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxHooks(t *testing.T) {
+	db, _ := newFakeDB("master", "mysql")
+	adapter := NewWith(db)
+	ctx := context.Background()
+
+	var calls []string
+	hook := func(name string) func(ctx context.Context) {
+		return func(ctx context.Context) {
+			calls = append(calls, name)
+		}
+	}
+
+	err := adapter.ExecuteTx(ctx, func(txCtx context.Context) error {
+		AfterCommit(txCtx, hook("commit"))
+		AfterRollback(txCtx, hook("rollback"))
+		// the hooks of a rolled back savepoint are dropped
+		adapter.ExecuteTx(txCtx, func(txCtx context.Context) error {
+			adapter.AfterCommit(txCtx, hook("nested commit"))
+			return errors.New("nested")
+		})
+		adapter.ExecuteTx(txCtx, func(txCtx context.Context) error {
+			adapter.AfterCommit(txCtx, hook("released commit"))
+			return nil
+		})
+		assert.Empty(t, calls, "hooks wait for the outermost tx")
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"commit", "released commit"}, calls)
+
+	calls = nil
+	err = adapter.ExecuteTx(ctx, func(txCtx context.Context) error {
+		AfterCommit(txCtx, hook("commit"))
+		AfterRollback(txCtx, hook("rollback"))
+		return errors.New("failed")
+	})
+	require.Error(t, err)
+	assert.Equal(t, []string{"rollback"}, calls)
+
+	calls = nil
+	AfterCommit(ctx, hook("no tx commit"))
+	AfterRollback(ctx, hook("no tx rollback"))
+	assert.Equal(t, []string{"no tx commit"}, calls)
+}
+
+func TestTxHooks_ScopedByDatabase(t *testing.T) {
+	ordersDB, _ := newFakeDB("orders", "mysql")
+	auditDB, _ := newFakeDB("audit", "mysql")
+	registry := NewRegistry()
+	orders := registry.Register("orders", NewRRCluster([]*sqlx.DB{ordersDB}, []*sqlx.DB{ordersDB}))
+	audit := registry.Register("audit", NewRRCluster([]*sqlx.DB{auditDB}, []*sqlx.DB{auditDB}))
+	ctx := context.Background()
+
+	var calls []string
+	err := audit.ExecuteTx(ctx, func(auditTxCtx context.Context) error {
+		audit.AfterCommit(auditTxCtx, func(ctx context.Context) {
+			calls = append(calls, "audit")
+		})
+		// orders has no tx in auditTxCtx, its hook must not attach to the audit tx
+		return orders.ExecuteTx(auditTxCtx, func(ctx context.Context) error {
+			orders.AfterCommit(ctx, func(ctx context.Context) {
+				calls = append(calls, "orders")
+			})
+			orders.AfterRollback(ctx, func(ctx context.Context) {
+				calls = append(calls, "orders rollback")
+			})
+			assert.Equal(t, []string{"orders"}, calls)
+			return nil
+		}, WithPropagation(PropagationSupports))
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"orders", "audit"}, calls)
+}
+
+func TestTxHooks_PanicAfterCommit(t *testing.T) {
+	db, node := newFakeDB("master", "mysql")
+	adapter := NewWith(db)
+	ctx := context.Background()
+
+	var calls []string
+	assert.PanicsWithValue(t, "hook", func() {
+		adapter.ExecuteTx(ctx, func(txCtx context.Context) error {
+			AfterCommit(txCtx, func(ctx context.Context) {
+				calls = append(calls, "commit")
+				panic("hook")
+			})
+			AfterRollback(txCtx, func(ctx context.Context) {
+				calls = append(calls, "rollback")
+			})
+			return nil
+		}, WithPanicError())
+	})
+	// the committed tx is not rolled back and its rollback hooks do not run
+	assert.Equal(t, []string{"BEGIN", "COMMIT"}, node.Log())
+	assert.Equal(t, []string{"commit"}, calls)
+}
@@ -0,0 +1,17 @@
+// Package backoff holds the retry backoff shared by the queue and outbox.
+package backoff
+
+import "time"
+
+// Exponential returns base doubled for every attempt after the first, it is
+// capped at max.
+func Exponential(base, max time.Duration, attempts int) time.Duration {
+	backoff := base
+	for i := 1; i < attempts && backoff < max; i++ {
+		backoff *= 2
+	}
+	if backoff > max {
+		backoff = max
+	}
+	return backoff
+}
@@ -0,0 +1,220 @@
+// Package fakedb is an in-memory database/sql driver for the tests of sqlxx and
+// its sub packages.
+package fakedb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Node is an in-memory driver recording the statements it receives, the
+// behavior of the node is changed through its fields.
+type Node struct {
+	lock    sync.Mutex
+	name    string
+	log     []string
+	connLog []int64
+	argLog  [][]driver.Value
+	failErr error
+	connSeq int64
+	closed  int64
+	// Rows returns the columns and rows of a query, default is one row of name
+	Rows func(query string) ([]string, [][]driver.Value)
+	// Affected returns the rows affected by an exec, default is 1
+	Affected func(query string) int64
+	Delay    time.Duration
+}
+
+func New(name, driverName string) (*sqlx.DB, *Node) {
+	node := &Node{name: name}
+	return sqlx.NewDb(sql.OpenDB(connector{node}), driverName), node
+}
+
+func (node *Node) record(connID int64, query string, args ...driver.NamedValue) {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	node.log = append(node.log, query)
+	node.connLog = append(node.connLog, connID)
+	vals := make([]driver.Value, len(args))
+	for i := range args {
+		vals[i] = args[i].Value
+	}
+	node.argLog = append(node.argLog, vals)
+}
+
+// Log returns the statements received by the node.
+func (node *Node) Log() []string {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	return append([]string(nil), node.log...)
+}
+
+// ConnLog returns the connections of the statements of Log.
+func (node *Node) ConnLog() []int64 {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	return append([]int64(nil), node.connLog...)
+}
+
+// ArgLog returns the args of the statements of Log.
+func (node *Node) ArgLog() [][]driver.Value {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	return append([][]driver.Value(nil), node.argLog...)
+}
+
+// Closed returns the number of physical connections closed.
+func (node *Node) Closed() int64 {
+	return atomic.LoadInt64(&node.closed)
+}
+
+func (node *Node) SetErr(err error) {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	node.failErr = err
+}
+
+func (node *Node) err() error {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	return node.failErr
+}
+
+func (node *Node) wait(ctx context.Context) error {
+	if node.Delay <= 0 {
+		return node.err()
+	}
+	select {
+	case <-time.After(node.Delay):
+		return node.err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type connector struct {
+	node *Node
+}
+
+func (c connector) Connect(context.Context) (driver.Conn, error) {
+	if err := c.node.err(); err != nil {
+		return nil, err
+	}
+	return &conn{node: c.node, id: atomic.AddInt64(&c.node.connSeq, 1)}, nil
+}
+
+func (c connector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, io.EOF
+}
+
+type conn struct {
+	node *Node
+	id   int64
+	inTx bool
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return nil, driver.ErrSkip
+}
+
+func (c *conn) Close() error {
+	atomic.AddInt64(&c.node.closed, 1)
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if c.inTx {
+		return nil, fmt.Errorf("conn %d is in a tx", c.id)
+	}
+	if opts.Isolation == 0 && !opts.ReadOnly {
+		c.node.record(c.id, "BEGIN")
+	} else {
+		c.node.record(c.id, fmt.Sprintf("BEGIN %d %v", opts.Isolation, opts.ReadOnly))
+	}
+	c.inTx = true
+	return tx{conn: c}, nil
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	return c.node.err()
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.node.record(c.id, query, args...)
+	if err := c.node.wait(ctx); err != nil {
+		return nil, err
+	}
+	if c.node.Affected != nil {
+		return driver.RowsAffected(c.node.Affected(query)), nil
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.node.record(c.id, query, args...)
+	if err := c.node.wait(ctx); err != nil {
+		return nil, err
+	}
+	cols, vals := []string{"v"}, [][]driver.Value{{c.node.name}}
+	if c.node.Rows != nil {
+		cols, vals = c.node.Rows(query)
+	}
+	return &rows{cols: cols, vals: vals}, nil
+}
+
+type tx struct {
+	conn *conn
+}
+
+func (tx tx) Commit() error {
+	tx.conn.node.record(tx.conn.id, "COMMIT")
+	tx.conn.inTx = false
+	return nil
+}
+
+func (tx tx) Rollback() error {
+	tx.conn.node.record(tx.conn.id, "ROLLBACK")
+	tx.conn.inTx = false
+	return nil
+}
+
+type rows struct {
+	cols []string
+	vals [][]driver.Value
+	i    int
+}
+
+func (r *rows) Columns() []string {
+	return r.cols
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.i >= len(r.vals) {
+		return io.EOF
+	}
+	copy(dest, r.vals[r.i])
+	r.i++
+	return nil
+}
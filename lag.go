@@ -0,0 +1,210 @@
+package sqlxx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type MaxStalenessKey struct{}
+
+// WithMaxStaleness routes the reads of ctx to the slaves whose replication lag is
+// under d, the reads fall back to master if no slave is fresh enough.
+func WithMaxStaleness(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, MaxStalenessKey{}, d)
+}
+
+func GetMaxStaleness(ctx context.Context) (time.Duration, bool) {
+	d, ok := ctx.Value(MaxStalenessKey{}).(time.Duration)
+	return d, ok
+}
+
+// LagProbe measures the replication lag of a slave.
+type LagProbe interface {
+	Lag(ctx context.Context, db *sqlx.DB) (time.Duration, error)
+}
+
+type LagProbeFunc func(ctx context.Context, db *sqlx.DB) (time.Duration, error)
+
+func (f LagProbeFunc) Lag(ctx context.Context, db *sqlx.DB) (time.Duration, error) {
+	return f(ctx, db)
+}
+
+var ErrNotReplicating = errors.New("db is not replicating")
+
+// MySQLLagProbe reads Seconds_Behind_Source from SHOW REPLICA STATUS, set Legacy
+// to use SHOW SLAVE STATUS on servers older than 8.0.22.
+type MySQLLagProbe struct {
+	Legacy bool
+}
+
+func (probe MySQLLagProbe) Lag(ctx context.Context, db *sqlx.DB) (time.Duration, error) {
+	query := "SHOW REPLICA STATUS"
+	if probe.Legacy {
+		query = "SHOW SLAVE STATUS"
+	}
+	status := make(map[string]interface{})
+	err := db.QueryRowxContext(ctx, query).MapScan(status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotReplicating
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	for _, col := range []string{"Seconds_Behind_Source", "Seconds_Behind_Master"} {
+		val, ok := status[col]
+		if !ok {
+			continue
+		}
+		seconds, err := parseSeconds(val)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	return 0, fmt.Errorf("%s has no seconds behind column", query)
+}
+
+// PostgresLagProbe compares now() with pg_last_xact_replay_timestamp(), a standby
+// which has replayed all received wal is reported as zero lag.
+type PostgresLagProbe struct{}
+
+func (probe PostgresLagProbe) Lag(ctx context.Context, db *sqlx.DB) (time.Duration, error) {
+	var seconds sql.NullFloat64
+	err := db.QueryRowxContext(ctx, `SELECT CASE
+		WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())
+	END`).Scan(&seconds)
+	if err != nil {
+		return 0, err
+	}
+	if !seconds.Valid {
+		return 0, ErrNotReplicating
+	}
+	return time.Duration(seconds.Float64 * float64(time.Second)), nil
+}
+
+func parseSeconds(val interface{}) (float64, error) {
+	switch v := val.(type) {
+	case nil:
+		return 0, ErrNotReplicating
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case []byte:
+		return strconv.ParseFloat(string(v), 64)
+	case string:
+		return strconv.ParseFloat(v, 64)
+	default:
+		return 0, fmt.Errorf("unsupported seconds behind value(%v)", val)
+	}
+}
+
+// EnableLagProbe starts a background worker which measures the lag of every slave
+// with probe, it is required by WithMaxStaleness.
+func (c *Cluster) EnableLagProbe(probe LagProbe, interval time.Duration) *LagMonitor {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	m := &LagMonitor{
+		probe:    probe,
+		interval: interval,
+		slaves:   c.Slaves(),
+		lags:     make(map[*sqlx.DB]lagState),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	c.lock.Lock()
+	prev := c.lag
+	c.lag = m
+	c.lock.Unlock()
+	if prev != nil {
+		prev.Close()
+	}
+
+	go m.run()
+	return m
+}
+
+type lagState struct {
+	lag        time.Duration
+	measuredAt time.Time
+}
+
+type LagMonitor struct {
+	probe    LagProbe
+	interval time.Duration
+	slaves   []*sqlx.DB
+	lock     sync.RWMutex
+	lags     map[*sqlx.DB]lagState
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+// Lag returns the last measured lag of db plus the time elapsed since the
+// measurement, ok is false if db has not been measured successfully.
+func (m *LagMonitor) Lag(db *sqlx.DB) (time.Duration, bool) {
+	m.lock.RLock()
+	state, ok := m.lags[db]
+	m.lock.RUnlock()
+	if !ok {
+		return 0, false
+	}
+	return state.lag + time.Since(state.measuredAt), true
+}
+
+func (m *LagMonitor) Close() error {
+	m.once.Do(func() {
+		close(m.stop)
+	})
+	<-m.done
+	return nil
+}
+
+func (m *LagMonitor) run() {
+	defer close(m.done)
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		m.refresh()
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *LagMonitor) refresh() {
+	wg := sync.WaitGroup{}
+	for _, db := range m.slaves {
+		wg.Add(1)
+		go func(db *sqlx.DB) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), m.interval)
+			defer cancel()
+			start := time.Now()
+			lag, err := m.probe.Lag(ctx, db)
+
+			m.lock.Lock()
+			defer m.lock.Unlock()
+			if err != nil {
+				delete(m.lags, db)
+				return
+			}
+			m.lags[db] = lagState{lag: lag, measuredAt: start}
+		}(db)
+	}
+	wg.Wait()
+}
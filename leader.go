@@ -0,0 +1,226 @@
+package sqlxx
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/vx416/sqlxx/builder"
+)
+
+// LeaderConfig configures a LeaderElector, the lease table of MySQL looks like
+//
+//	CREATE TABLE leader_leases (
+//		name       VARCHAR(255) PRIMARY KEY,
+//		holder     VARCHAR(255) NOT NULL,
+//		expires_at DATETIME(6) NOT NULL
+//	)
+//
+// expires_at is TIMESTAMPTZ on Postgres, the lease uses the database time so
+// the clocks of the candidates do not matter.
+type LeaderConfig struct {
+	// Name is the name of the election
+	Name string
+	// Table is the lease table, default is leader_leases
+	Table string
+	// Holder identifies the candidate, default is hostname-pid
+	Holder string
+	// TTL is how long a lease lasts without renewal, default is 15s
+	TTL time.Duration
+	// RenewInterval is the interval to renew or take the lease, default is TTL/3
+	RenewInterval time.Duration
+	// OnElected is called in a goroutine when the lease is taken, ctx is canceled
+	// when the lease is lost
+	OnElected func(ctx context.Context)
+	// OnRevoked is called when the lease is lost or given up
+	OnRevoked func()
+	OnError   func(err error)
+}
+
+func (cfg *LeaderConfig) setDefault() {
+	if cfg.Table == "" {
+		cfg.Table = "leader_leases"
+	}
+	if cfg.Holder == "" {
+		hostname, _ := os.Hostname()
+		cfg.Holder = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 15 * time.Second
+	}
+	if cfg.RenewInterval <= 0 {
+		cfg.RenewInterval = cfg.TTL / 3
+	}
+}
+
+// NewLeaderElector elects one leader among the candidates sharing cfg.Name by a
+// lease row, the leader renews the lease before it expires.
+func (adapter *Sqlxx) NewLeaderElector(cfg LeaderConfig) *LeaderElector {
+	cfg.setDefault()
+	return &LeaderElector{
+		adapter: adapter,
+		cfg:     cfg,
+	}
+}
+
+type LeaderElector struct {
+	adapter *Sqlxx
+	cfg     LeaderConfig
+
+	lock      sync.RWMutex
+	leader    bool
+	expiresAt time.Time
+	cancel    context.CancelFunc
+}
+
+func (le *LeaderElector) IsLeader() bool {
+	le.lock.RLock()
+	defer le.lock.RUnlock()
+	return le.leader && time.Now().Before(le.expiresAt)
+}
+
+// Run takes part in the election until ctx is done, the lease is given up
+// when it returns.
+func (le *LeaderElector) Run(ctx context.Context) error {
+	ticker := time.NewTicker(le.cfg.RenewInterval)
+	defer ticker.Stop()
+
+	for {
+		le.renew(ctx)
+		select {
+		case <-ctx.Done():
+			le.resign()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (le *LeaderElector) renew(ctx context.Context) {
+	// the lease is checked and extended with the database time, the local
+	// expiry starts before the tx so it never outlives the lease in the table
+	expiresAt := time.Now().Add(le.cfg.TTL)
+	var acquired bool
+	err := le.adapter.ExecuteTx(WithMaster(ctx), func(txCtx context.Context) error {
+		db := le.adapter.GetDB(txCtx)
+		now, expiry := leaseTime(db.Dialect())
+		q, args, err := builder.Query().Select("name").From(le.cfg.Table).And("name = ?", le.cfg.Name).Lock(builder.MSWRITELOCK).Build()
+		if err != nil {
+			return err
+		}
+		names := []string{}
+		if err := db.SelectContext(txCtx, &names, db.Rebind(q), args...); err != nil {
+			return err
+		}
+
+		if len(names) == 0 {
+			ins := fmt.Sprintf("INSERT INTO %s (name, holder, expires_at) VALUES (?, ?, %s)", le.cfg.Table, expiry)
+			_, err := db.ExecContext(txCtx, db.Rebind(ins), le.cfg.Name, le.cfg.Holder, le.cfg.TTL.Microseconds())
+			acquired = err == nil
+			return err
+		}
+		q, args, err = builder.Update().Table(le.cfg.Table).
+			Set("holder = ?", le.cfg.Holder).
+			Set("expires_at = "+expiry, le.cfg.TTL.Microseconds()).
+			And("name = ?", le.cfg.Name).
+			And("(holder = ? OR expires_at <= "+now+")", le.cfg.Holder).
+			Build()
+		if err != nil {
+			return err
+		}
+		res, err := db.ExecContext(txCtx, db.Rebind(q), args...)
+		if err != nil {
+			return err
+		}
+		rows, err := res.RowsAffected()
+		acquired = err == nil && rows > 0
+		return err
+	}, WithPropagation(PropagationRequiresNew))
+
+	if err != nil {
+		if le.cfg.OnError != nil {
+			le.cfg.OnError(err)
+		}
+		// the lease is kept until it expires
+		if le.IsLeader() {
+			return
+		}
+	}
+	if acquired {
+		le.elected(ctx, expiresAt)
+	} else {
+		le.revoked()
+	}
+}
+
+// leaseTime returns the current time of dialect and the time after a number of
+// microseconds given as the argument.
+func leaseTime(dialect Dialect) (now, after string) {
+	if dialect == Postgres {
+		return "now()", "now() + ? * INTERVAL '1 microsecond'"
+	}
+	return "NOW(6)", "NOW(6) + INTERVAL ? MICROSECOND"
+}
+
+func (le *LeaderElector) elected(ctx context.Context, expiresAt time.Time) {
+	le.lock.Lock()
+	le.expiresAt = expiresAt
+	if le.leader {
+		le.lock.Unlock()
+		return
+	}
+	le.leader = true
+	leaderCtx, cancel := context.WithCancel(ctx)
+	le.cancel = cancel
+	le.lock.Unlock()
+
+	if le.cfg.OnElected != nil {
+		go le.cfg.OnElected(leaderCtx)
+	}
+}
+
+func (le *LeaderElector) revoked() {
+	le.lock.Lock()
+	if !le.leader {
+		le.lock.Unlock()
+		return
+	}
+	le.leader = false
+	le.cancel()
+	le.lock.Unlock()
+
+	if le.cfg.OnRevoked != nil {
+		le.cfg.OnRevoked()
+	}
+}
+
+// resign expires the lease so the other candidates can take it at once.
+func (le *LeaderElector) resign() {
+	leader := le.IsLeader()
+	le.revoked()
+	if !leader {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), le.cfg.RenewInterval)
+	defer cancel()
+	err := le.adapter.ExecuteTx(WithMaster(ctx), func(txCtx context.Context) error {
+		db := le.adapter.GetDB(txCtx)
+		now, _ := leaseTime(db.Dialect())
+		q, args, err := builder.Update().Table(le.cfg.Table).
+			Set("expires_at = "+now, nil).
+			And("name = ?", le.cfg.Name).
+			And("holder = ?", le.cfg.Holder).
+			Build()
+		if err != nil {
+			return err
+		}
+		_, err = db.ExecContext(txCtx, db.Rebind(q), args...)
+		return err
+	}, WithPropagation(PropagationRequiresNew))
+	if err != nil && le.cfg.OnError != nil {
+		le.cfg.OnError(err)
+	}
+}
@@ -0,0 +1,111 @@
+package sqlxx
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaderElector_Renew(t *testing.T) {
+	db, node := newFakeDB("master", "mysql")
+	var (
+		leaseExists int32
+		updated     int64 = 1
+	)
+	node.Rows = func(query string) ([]string, [][]driver.Value) {
+		if atomic.LoadInt32(&leaseExists) == 0 {
+			return []string{"name"}, nil
+		}
+		return []string{"name"}, [][]driver.Value{{"jobs"}}
+	}
+	node.Affected = func(query string) int64 {
+		if strings.HasPrefix(query, "UPDATE") {
+			return atomic.LoadInt64(&updated)
+		}
+		return 1
+	}
+
+	elected := make(chan struct{}, 1)
+	le := NewWith(db).NewLeaderElector(LeaderConfig{
+		Name:   "jobs",
+		Holder: "a",
+		TTL:    time.Second,
+		OnElected: func(ctx context.Context) {
+			elected <- struct{}{}
+		},
+	})
+	ctx := context.Background()
+
+	le.renew(ctx)
+	assert.True(t, le.IsLeader())
+	<-elected
+	assert.Contains(t, node.Log(), "INSERT INTO leader_leases (name, holder, expires_at) VALUES (?, ?, NOW(6) + INTERVAL ? MICROSECOND)")
+
+	atomic.StoreInt32(&leaseExists, 1)
+	le.renew(ctx)
+	assert.True(t, le.IsLeader())
+	assert.Contains(t, node.Log(), "UPDATE leader_leases SET holder = ?, expires_at = NOW(6) + INTERVAL ? MICROSECOND WHERE name = ? AND (holder = ? OR expires_at <= NOW(6))")
+
+	// the lease is held by another candidate
+	atomic.StoreInt64(&updated, 0)
+	le.renew(ctx)
+	assert.False(t, le.IsLeader())
+}
+
+func TestLeaderElector_RenewPostgres(t *testing.T) {
+	db, node := newFakeDB("master", "postgres")
+	leaseExists := false
+	node.Rows = func(query string) ([]string, [][]driver.Value) {
+		if !leaseExists {
+			return []string{"name"}, nil
+		}
+		return []string{"name"}, [][]driver.Value{{"jobs"}}
+	}
+	var errs []error
+	le := NewWith(db).NewLeaderElector(LeaderConfig{
+		Name:   "jobs",
+		Holder: "a",
+		TTL:    time.Second,
+		OnError: func(err error) {
+			errs = append(errs, err)
+		},
+	})
+	ctx := context.Background()
+
+	le.renew(ctx)
+	leaseExists = true
+	le.renew(ctx)
+	le.resign()
+	assert.Empty(t, errs)
+	assert.Equal(t, []string{
+		"BEGIN",
+		"SELECT name FROM leader_leases WHERE name = $1 FOR UPDATE",
+		"INSERT INTO leader_leases (name, holder, expires_at) VALUES ($1, $2, now() + $3 * INTERVAL '1 microsecond')",
+		"COMMIT",
+		"BEGIN",
+		"SELECT name FROM leader_leases WHERE name = $1 FOR UPDATE",
+		"UPDATE leader_leases SET holder = $1, expires_at = now() + $2 * INTERVAL '1 microsecond' WHERE name = $3 AND (holder = $4 OR expires_at <= now())",
+		"COMMIT",
+		"BEGIN",
+		"UPDATE leader_leases SET expires_at = now() WHERE name = $1 AND holder = $2",
+		"COMMIT",
+	}, node.Log())
+	for _, query := range node.Log() {
+		assert.NotContains(t, query, "?")
+	}
+}
+
+func TestLeaseTime(t *testing.T) {
+	now, after := leaseTime(Postgres)
+	assert.Equal(t, "now()", now)
+	assert.Equal(t, "now() + ? * INTERVAL '1 microsecond'", after)
+
+	now, after = leaseTime(MySQL)
+	assert.Equal(t, "NOW(6)", now)
+	assert.Equal(t, "NOW(6) + INTERVAL ? MICROSECOND", after)
+}
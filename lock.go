@@ -0,0 +1,182 @@
+package sqlxx
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrLockTimeout     = errors.New("lock is not acquired in time")
+	ErrLockNotHeld     = errors.New("lock is not held")
+	ErrLockUnsupported = errors.New("advisory lock is not supported by the dialect")
+)
+
+// Lock is an advisory lock held by a connection pinned for the lifetime of the
+// lock. The connection is thrown away instead of returned to the pool when the
+// lock may still be held, the server releases the lock when it is closed.
+type Lock struct {
+	name    string
+	dialect Dialect
+	conn    *sqlx.Conn
+	// release returns the conn checked out for the lock, it does nothing for
+	// the conn pinned by WithConn
+	release func()
+	once    sync.Once
+}
+
+// AcquireLock waits up to timeout for the advisory lock name on the master, it
+// is backed by GET_LOCK on MySQL and pg_advisory_lock on Postgres. The lock is
+// taken on the pinned conn of WithConn if there is one.
+func (db *DB) AcquireLock(ctx context.Context, name string, timeout time.Duration) (*Lock, error) {
+	return db.lock(ctx, name, timeout)
+}
+
+// TryLock takes the advisory lock name on the master if it is free, ok is false
+// if the lock is held by others.
+func (db *DB) TryLock(ctx context.Context, name string) (lock *Lock, ok bool, err error) {
+	lock, err = db.lock(ctx, name, 0)
+	if errors.Is(err, ErrLockTimeout) {
+		return nil, false, nil
+	}
+	return lock, err == nil, err
+}
+
+func (db *DB) lock(ctx context.Context, name string, timeout time.Duration) (*Lock, error) {
+	connDB := db
+	if db.Conn == nil {
+		var err error
+		connDB, err = db.PinConn(WithMaster(ctx))
+		if err != nil {
+			return nil, err
+		}
+	}
+	release := func() {
+		if connDB != db {
+			connDB.ReleaseConn()
+		}
+	}
+
+	dialect := connDB.Dialect()
+	if dialect != MySQL && dialect != Postgres {
+		release()
+		return nil, ErrLockUnsupported
+	}
+	lock := &Lock{name: name, dialect: dialect, conn: connDB.Conn, release: release}
+	acquired, err := lock.acquire(ctx, timeout)
+	if err != nil || !acquired {
+		// a failed or canceled statement may still have been granted the lock
+		if err != nil || (dialect == Postgres && timeout > 0) {
+			discardConn(lock.conn)
+		}
+		release()
+		if err == nil {
+			err = ErrLockTimeout
+		}
+		return nil, err
+	}
+	return lock, nil
+}
+
+func (lock *Lock) acquire(ctx context.Context, timeout time.Duration) (bool, error) {
+	var acquired sql.NullBool
+	switch {
+	case lock.dialect == MySQL:
+		seconds := int64(math.Ceil(timeout.Seconds()))
+		err := lock.conn.GetContext(ctx, &acquired, "SELECT GET_LOCK(?, ?)", lock.name, seconds)
+		if err != nil {
+			return false, err
+		}
+		if !acquired.Valid {
+			return false, errors.New("GET_LOCK failed")
+		}
+		return acquired.Bool, nil
+	case timeout <= 0:
+		err := lock.conn.GetContext(ctx, &acquired, "SELECT pg_try_advisory_lock($1)", lock.key())
+		return acquired.Bool, err
+	default:
+		lockCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		_, err := lock.conn.ExecContext(lockCtx, "SELECT pg_advisory_lock($1)", lock.key())
+		if err != nil && ctx.Err() == nil && lockCtx.Err() != nil {
+			return false, nil
+		}
+		return err == nil, err
+	}
+}
+
+// key maps the name to the bigint key of the Postgres advisory lock.
+func (lock *Lock) key() int64 {
+	h := fnv.New64a()
+	h.Write([]byte(lock.name))
+	return int64(h.Sum64())
+}
+
+func (lock *Lock) Name() string {
+	return lock.name
+}
+
+const lockReleaseTimeout = 5 * time.Second
+
+// Release releases the lock and returns the connection checked out for it to
+// the pool. The unlock is not canceled with ctx but bounded by 5s, the
+// connection is thrown away if the unlock fails.
+func (lock *Lock) Release(ctx context.Context) error {
+	err := ErrLockNotHeld
+	lock.once.Do(func() {
+		defer lock.release()
+		unlockCtx, cancel := context.WithTimeout(detachContext(ctx), lockReleaseTimeout)
+		defer cancel()
+
+		var released sql.NullBool
+		if lock.dialect == MySQL {
+			err = lock.conn.GetContext(unlockCtx, &released, "SELECT RELEASE_LOCK(?)", lock.name)
+		} else {
+			err = lock.conn.GetContext(unlockCtx, &released, "SELECT pg_advisory_unlock($1)", lock.key())
+		}
+		if err != nil {
+			discardConn(lock.conn)
+			return
+		}
+		if !released.Bool {
+			err = ErrLockNotHeld
+		}
+	})
+	return err
+}
+
+// discardConn closes the physical connection of conn, it is not returned to
+// the pool so the session level locks held by it are released by the server.
+func discardConn(conn *sqlx.Conn) {
+	conn.Raw(func(driverConn interface{}) error {
+		return driver.ErrBadConn
+	})
+}
+
+// detachedContext keeps the values of its parent but not its cancellation.
+type detachedContext struct {
+	context.Context
+}
+
+func detachContext(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
@@ -0,0 +1,77 @@
+package sqlxx
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newLockFakeDB(driverName string) (*Sqlxx, *fakeNode) {
+	db, node := newFakeDB("master", driverName)
+	node.Rows = func(query string) ([]string, [][]driver.Value) {
+		return []string{"v"}, [][]driver.Value{{int64(1)}}
+	}
+	return NewWith(db), node
+}
+
+func TestLock_Release(t *testing.T) {
+	adapter, node := newLockFakeDB("mysql")
+	ctx := context.Background()
+
+	lock, err := adapter.GetDB(ctx).AcquireLock(ctx, "jobs", time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "jobs", lock.Name())
+
+	// the unlock is not canceled with ctx
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	require.NoError(t, lock.Release(canceled))
+	assert.Equal(t, []string{"SELECT GET_LOCK(?, ?)", "SELECT RELEASE_LOCK(?)"}, node.Log())
+	assert.Equal(t, int64(0), node.Closed(), "the conn is returned to the pool")
+	assert.Equal(t, ErrLockNotHeld, lock.Release(ctx))
+}
+
+func TestLock_ReleaseFailed(t *testing.T) {
+	adapter, node := newLockFakeDB("postgres")
+	ctx := context.Background()
+
+	lock, err := adapter.GetDB(ctx).AcquireLock(ctx, "jobs", time.Second)
+	require.NoError(t, err)
+
+	unlockErr := errors.New("unlock failed")
+	node.SetErr(unlockErr)
+	assert.Equal(t, unlockErr, lock.Release(ctx))
+	assert.Equal(t, []string{"SELECT pg_advisory_lock($1)", "SELECT pg_advisory_unlock($1)"}, node.Log())
+	assert.Equal(t, int64(1), node.Closed(), "the conn may hold the lock and is thrown away")
+}
+
+func TestLock_AcquireFailed(t *testing.T) {
+	adapter, node := newLockFakeDB("mysql")
+	ctx := context.Background()
+
+	node.Rows = func(query string) ([]string, [][]driver.Value) {
+		return []string{"v"}, [][]driver.Value{{int64(0)}}
+	}
+	_, ok, err := adapter.GetDB(ctx).TryLock(ctx, "jobs")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, int64(0), node.Closed(), "GET_LOCK returning 0 holds nothing")
+
+	node.Delay = time.Second
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = adapter.GetDB(ctx).AcquireLock(timeout, "jobs", time.Second)
+	assert.Error(t, err)
+	assert.Equal(t, int64(1), node.Closed(), "the canceled GET_LOCK may have been granted")
+}
+
+func TestLock_Unsupported(t *testing.T) {
+	adapter, _ := newLockFakeDB("sqlite3")
+	_, err := adapter.GetDB(context.Background()).AcquireLock(context.Background(), "jobs", time.Second)
+	assert.Equal(t, ErrLockUnsupported, err)
+}
@@ -29,6 +29,8 @@ var (
 	LogLevel      = Debug
 	SlowThreshold = 1 * time.Second
 	Colorful      = false
+	// LongTxThreshold is how long a tx stays open before a warning, zero disables it
+	LongTxThreshold = 10 * time.Second
 )
 
 func AttachLogger(ctx context.Context, log Logger) context.Context {
@@ -103,6 +105,26 @@ func Print(ctx context.Context, rows int64, err error, cost time.Duration, query
 	}
 }
 
+func PrintLongTx(ctx context.Context, cost time.Duration, stack []byte) {
+	if getLevel(ctx) == Off {
+		return
+	}
+
+	l := GetLogger(ctx)
+	if l == nil {
+		return
+	}
+
+	msg := fmt.Sprintf("transaction is open for %s", cost)
+	if Colorful {
+		msg = colorize(msg, colorYellow)
+	}
+	l.Warn(msg, map[string]interface{}{
+		"tx_cost": cost,
+		"stack":   string(stack),
+	})
+}
+
 func colorize(s string, c int) string {
 	return fmt.Sprintf("\x1b[%dm%s\x1b[0m", c, s)
 }
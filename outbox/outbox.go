@@ -0,0 +1,128 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/vx416/sqlxx"
+	"github.com/vx416/sqlxx/builder"
+	"gopkg.in/guregu/null.v4"
+)
+
+const (
+	StatusPending = "pending"
+	StatusSent    = "sent"
+	StatusDead    = "dead"
+)
+
+// Event is a row of the outbox table, the table looks like
+//
+//	CREATE TABLE outbox (
+//		id              BIGINT AUTO_INCREMENT PRIMARY KEY,
+//		topic           VARCHAR(255) NOT NULL,
+//		event_key       VARCHAR(255) NOT NULL DEFAULT '',
+//		payload         BLOB NOT NULL,
+//		status          VARCHAR(16) NOT NULL DEFAULT 'pending',
+//		attempts        INT NOT NULL DEFAULT 0,
+//		last_error      TEXT NULL,
+//		next_attempt_at DATETIME(6) NOT NULL,
+//		created_at      DATETIME(6) NOT NULL,
+//		sent_at         DATETIME(6) NULL,
+//		INDEX idx_outbox_status (status, next_attempt_at)
+//	)
+type Event struct {
+	ID            int64       `db:"id"`
+	Topic         string      `db:"topic"`
+	Key           string      `db:"event_key"`
+	Payload       []byte      `db:"payload"`
+	Status        string      `db:"status"`
+	Attempts      int         `db:"attempts"`
+	LastError     null.String `db:"last_error"`
+	NextAttemptAt time.Time   `db:"next_attempt_at"`
+	CreatedAt     time.Time   `db:"created_at"`
+	SentAt        null.Time   `db:"sent_at"`
+}
+
+type Config struct {
+	// Table is the outbox table, default is outbox
+	Table string
+	// BatchSize is the events relayed in one tx, default is 100
+	BatchSize int
+	// PollInterval is the wait between polls when the outbox is drained, default is 1s
+	PollInterval time.Duration
+	// MaxAttempts is the attempts before an event is dead, default is 10
+	MaxAttempts int
+	// Backoff is the wait before the first retry, it doubles with every attempt up
+	// to MaxBackoff, default is 1s and 10m
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// OnError is called with the errors of the relay loop
+	OnError func(err error)
+}
+
+func (cfg *Config) setDefault() {
+	if cfg.Table == "" {
+		cfg.Table = "outbox"
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 10
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = time.Second
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 10 * time.Minute
+	}
+}
+
+func New(db *sqlxx.Sqlxx, cfg Config) *Outbox {
+	cfg.setDefault()
+	return &Outbox{
+		db:  db,
+		cfg: cfg,
+	}
+}
+
+type Outbox struct {
+	db  *sqlxx.Sqlxx
+	cfg Config
+}
+
+// Add writes events to the outbox in the tx of ctx, sqlxx.ErrNoTx is returned if
+// ctx has no tx.
+func (o *Outbox) Add(ctx context.Context, events ...*Event) error {
+	return o.db.ExecuteTx(ctx, func(txCtx context.Context) error {
+		now := time.Now()
+		for _, event := range events {
+			event.Status = StatusPending
+			event.CreatedAt = now
+			event.NextAttemptAt = now
+			res, err := o.db.GetDB(txCtx).Exec(txCtx, builder.Insert().Table(o.cfg.Table).InsertRows(event))
+			if err != nil {
+				return err
+			}
+			if id, err := res.LastInsertId(); err == nil {
+				event.ID = id
+			}
+		}
+		return nil
+	}, sqlxx.WithPropagation(sqlxx.PropagationMandatory))
+}
+
+// Requeue moves dead events back to pending with their attempts reset.
+func (o *Outbox) Requeue(ctx context.Context, ids ...int64) error {
+	q := builder.Update().Table(o.cfg.Table).
+		Set("status = ?", StatusPending).
+		Set("attempts = ?", 0).
+		Set("next_attempt_at = ?", time.Now()).
+		AndIn("id IN (?)", ids).
+		And("status = ?", StatusDead)
+	_, err := o.db.GetDB(ctx).Exec(ctx, q)
+	return err
+}
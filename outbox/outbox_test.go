@@ -0,0 +1,118 @@
+package outbox
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vx416/sqlxx"
+	"github.com/vx416/sqlxx/internal/fakedb"
+)
+
+var eventColumns = []string{"id", "topic", "event_key", "payload", "status", "attempts", "last_error", "next_attempt_at", "created_at", "sent_at"}
+
+func eventRow(id int64, attempts int) []driver.Value {
+	now := time.Now()
+	return []driver.Value{id, "orders", "", []byte("payload"), StatusPending, int64(attempts), nil, now, now, nil}
+}
+
+func TestOutbox_Add(t *testing.T) {
+	db, node := fakedb.New("master", "mysql")
+	adapter := sqlxx.NewWith(db)
+	o := New(adapter, Config{})
+	ctx := context.Background()
+
+	assert.ErrorIs(t, o.Add(ctx, &Event{Topic: "orders"}), sqlxx.ErrNoTx)
+	assert.Empty(t, node.Log())
+
+	event := &Event{Topic: "orders"}
+	err := adapter.ExecuteTx(ctx, func(txCtx context.Context) error {
+		return o.Add(txCtx, event)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, StatusPending, event.Status)
+	log := node.Log()
+	require.Len(t, log, 3)
+	assert.True(t, strings.HasPrefix(log[1], "INSERT INTO outbox "))
+	assert.Equal(t, "COMMIT", log[2])
+}
+
+func TestRelay_RelayOnce(t *testing.T) {
+	db, node := fakedb.New("master", "mysql")
+	node.Rows = func(query string) ([]string, [][]driver.Value) {
+		return eventColumns, [][]driver.Value{eventRow(1, 0), eventRow(2, 0)}
+	}
+	o := New(sqlxx.NewWith(db), Config{BatchSize: 2})
+
+	var published []*Event
+	relay := o.Relay(PublisherFunc(func(ctx context.Context, events []*Event) error {
+		published = events
+		return nil
+	}))
+	n, err := relay.RelayOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Len(t, published, 2)
+
+	log, args := node.Log(), node.ArgLog()
+	require.Len(t, log, 4)
+	assert.Equal(t, "SELECT * FROM outbox WHERE status = ? AND next_attempt_at <= ? ORDER BY id LIMIT 2 FOR UPDATE SKIP LOCKED", log[1])
+	assert.Equal(t, "UPDATE outbox SET status = ?, sent_at = ? WHERE id IN (?, ?)", log[2])
+	assert.Equal(t, StatusSent, args[2][0])
+	assert.Equal(t, []driver.Value{int64(1), int64(2)}, args[2][2:])
+	assert.Equal(t, "COMMIT", log[3])
+}
+
+func TestRelay_RelayOnceFailed(t *testing.T) {
+	db, node := fakedb.New("master", "mysql")
+	node.Rows = func(query string) ([]string, [][]driver.Value) {
+		return eventColumns, [][]driver.Value{eventRow(1, 1), eventRow(2, 2)}
+	}
+	o := New(sqlxx.NewWith(db), Config{MaxAttempts: 3, Backoff: time.Second, MaxBackoff: time.Minute})
+
+	var published []*Event
+	publishErr := errors.New("broker is down")
+	relay := o.Relay(PublisherFunc(func(ctx context.Context, events []*Event) error {
+		published = events
+		return publishErr
+	}))
+	before := time.Now()
+	n, err := relay.RelayOnce(context.Background())
+	assert.ErrorIs(t, err, publishErr)
+	assert.Equal(t, 2, n)
+
+	// the first event is retried after the backoff of its second attempt
+	require.Len(t, published, 2)
+	assert.Equal(t, 2, published[0].Attempts)
+	assert.Equal(t, StatusPending, published[0].Status)
+	assert.WithinDuration(t, before.Add(2*time.Second), published[0].NextAttemptAt, time.Second)
+	// the second event is out of attempts
+	assert.Equal(t, 3, published[1].Attempts)
+	assert.Equal(t, StatusDead, published[1].Status)
+	assert.Equal(t, "broker is down", published[1].LastError.String)
+
+	log, args := node.Log(), node.ArgLog()
+	require.Len(t, log, 5)
+	assert.Equal(t, "UPDATE outbox SET attempts = ?, last_error = ?, next_attempt_at = ? WHERE id = ?", log[2])
+	assert.Equal(t, "UPDATE outbox SET attempts = ?, last_error = ?, status = ? WHERE id = ?", log[3])
+	assert.Equal(t, []driver.Value{int64(3), "broker is down", StatusDead, int64(2)}, args[3])
+	assert.Equal(t, "COMMIT", log[4])
+}
+
+func TestOutbox_Requeue(t *testing.T) {
+	db, node := fakedb.New("master", "mysql")
+	o := New(sqlxx.NewWith(db), Config{})
+
+	require.NoError(t, o.Requeue(context.Background(), 1, 2))
+	log, args := node.Log(), node.ArgLog()
+	require.Len(t, log, 1)
+	assert.Equal(t, "UPDATE outbox SET status = ?, attempts = ?, next_attempt_at = ? WHERE id IN (?, ?) AND status = ?", log[0])
+	assert.Equal(t, StatusPending, args[0][0])
+	assert.Equal(t, int64(0), args[0][1])
+	assert.Equal(t, []driver.Value{int64(1), int64(2), StatusDead}, args[0][3:])
+}
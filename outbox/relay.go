@@ -0,0 +1,132 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/vx416/sqlxx/builder"
+	"github.com/vx416/sqlxx/internal/backoff"
+)
+
+// Publisher sends events to the broker, the events are retried if an error is
+// returned.
+type Publisher interface {
+	Publish(ctx context.Context, events []*Event) error
+}
+
+type PublisherFunc func(ctx context.Context, events []*Event) error
+
+func (f PublisherFunc) Publish(ctx context.Context, events []*Event) error {
+	return f(ctx, events)
+}
+
+// Relay polls the outbox and hands the pending events to the publisher, the
+// rows are locked with SKIP LOCKED so relays can run on several instances.
+func (o *Outbox) Relay(publisher Publisher) *Relay {
+	return &Relay{
+		outbox:    o,
+		publisher: publisher,
+	}
+}
+
+type Relay struct {
+	outbox    *Outbox
+	publisher Publisher
+}
+
+// Run relays events until ctx is done.
+func (r *Relay) Run(ctx context.Context) error {
+	cfg := r.outbox.cfg
+	for {
+		n, err := r.RelayOnce(ctx)
+		if err != nil && cfg.OnError != nil {
+			cfg.OnError(err)
+		}
+		if n == cfg.BatchSize && err == nil {
+			continue
+		}
+
+		timer := time.NewTimer(cfg.PollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// RelayOnce relays one batch of events and returns the number of events.
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	var (
+		events     []*Event
+		publishErr error
+	)
+	err := r.outbox.db.ExecuteTx(ctx, func(txCtx context.Context) error {
+		var err error
+		events, err = r.outbox.lockPending(txCtx)
+		if err != nil || len(events) == 0 {
+			return err
+		}
+
+		publishErr = r.publisher.Publish(txCtx, events)
+		if publishErr != nil {
+			return r.outbox.markFailed(txCtx, events, publishErr)
+		}
+		return r.outbox.markSent(txCtx, events)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(events), publishErr
+}
+
+func (o *Outbox) lockPending(ctx context.Context) ([]*Event, error) {
+	q := builder.Query().Select("*").From(o.cfg.Table).
+		And("status = ?", StatusPending).
+		And("next_attempt_at <= ?", time.Now()).
+		OrderBy("id").
+		LimitOffset(o.cfg.BatchSize, 0).
+		SkipLocked()
+
+	events := make([]*Event, 0, o.cfg.BatchSize)
+	err := o.db.GetDB(ctx).Select(ctx, &events, q)
+	return events, err
+}
+
+func (o *Outbox) markSent(ctx context.Context, events []*Event) error {
+	ids := make([]int64, len(events))
+	for i := range events {
+		ids[i] = events[i].ID
+	}
+	q := builder.Update().Table(o.cfg.Table).
+		Set("status = ?", StatusSent).
+		Set("sent_at = ?", time.Now()).
+		AndIn("id IN (?)", ids)
+	_, err := o.db.GetDB(ctx).Exec(ctx, q)
+	return err
+}
+
+// markFailed schedules the retry of events, the events out of attempts are dead.
+func (o *Outbox) markFailed(ctx context.Context, events []*Event, publishErr error) error {
+	now := time.Now()
+	for _, event := range events {
+		event.Attempts++
+		event.LastError.SetValid(publishErr.Error())
+		q := builder.Update().Table(o.cfg.Table).
+			Set("attempts = ?", event.Attempts).
+			Set("last_error = ?", event.LastError)
+		if event.Attempts >= o.cfg.MaxAttempts {
+			event.Status = StatusDead
+			q.Set("status = ?", StatusDead)
+		} else {
+			event.NextAttemptAt = now.Add(backoff.Exponential(o.cfg.Backoff, o.cfg.MaxBackoff, event.Attempts))
+			q.Set("next_attempt_at = ?", event.NextAttemptAt)
+		}
+		q.And("id = ?", event.ID)
+		if _, err := o.db.GetDB(ctx).Exec(ctx, q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
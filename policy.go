@@ -0,0 +1,155 @@
+package sqlxx
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func NewRoundRubinPolicy(dbs []*sqlx.DB) *RoundRubinPolicy {
+	return &RoundRubinPolicy{dbs: dbs}
+}
+
+type RoundRubinPolicy struct {
+	dbs       []*sqlx.DB
+	lock      sync.Mutex
+	currIndex int
+}
+
+func (po *RoundRubinPolicy) Get(ctx context.Context) (*sqlx.DB, error) {
+	po.lock.Lock()
+	defer po.lock.Unlock()
+	if len(po.dbs) == 0 {
+		return nil, ErrNoDBPool
+	}
+
+	for i := 0; i < len(po.dbs); i++ {
+		if po.currIndex >= len(po.dbs) {
+			po.currIndex = 0
+		}
+		db := po.dbs[po.currIndex]
+		po.currIndex++
+		if NodeAllowed(ctx, db) {
+			return db, nil
+		}
+	}
+	return nil, ErrNoHealthyNode
+}
+
+func (po *RoundRubinPolicy) Nodes() []*sqlx.DB {
+	return copyNodes(po.dbs)
+}
+
+// NewWeightedPolicy returns a smooth weighted round-robin policy, dbs[i] receives
+// weights[i] out of every sum(weights) requests.
+func NewWeightedPolicy(dbs []*sqlx.DB, weights []int) (*WeightedPolicy, error) {
+	if len(dbs) != len(weights) {
+		return nil, errors.New("dbs and weights size is different")
+	}
+	for _, weight := range weights {
+		if weight <= 0 {
+			return nil, errors.New("weight should be greater than zero")
+		}
+	}
+
+	return &WeightedPolicy{
+		dbs:     dbs,
+		weights: weights,
+		current: make([]int, len(dbs)),
+	}, nil
+}
+
+type WeightedPolicy struct {
+	dbs     []*sqlx.DB
+	weights []int
+	lock    sync.Mutex
+	current []int
+}
+
+func (po *WeightedPolicy) Get(ctx context.Context) (*sqlx.DB, error) {
+	po.lock.Lock()
+	defer po.lock.Unlock()
+	if len(po.dbs) == 0 {
+		return nil, ErrNoDBPool
+	}
+
+	var (
+		total int
+		best  = -1
+	)
+	for i, weight := range po.weights {
+		if !NodeAllowed(ctx, po.dbs[i]) {
+			continue
+		}
+		po.current[i] += weight
+		total += weight
+		if best < 0 || po.current[i] > po.current[best] {
+			best = i
+		}
+	}
+	if best < 0 {
+		return nil, ErrNoHealthyNode
+	}
+	po.current[best] -= total
+	return po.dbs[best], nil
+}
+
+func (po *WeightedPolicy) Nodes() []*sqlx.DB {
+	return copyNodes(po.dbs)
+}
+
+// NewLeastConnPolicy returns a policy which picks the db with the fewest
+// in-use connections reported by sql.DB.Stats.
+func NewLeastConnPolicy(dbs []*sqlx.DB) *LeastConnPolicy {
+	return &LeastConnPolicy{dbs: dbs}
+}
+
+type LeastConnPolicy struct {
+	dbs       []*sqlx.DB
+	lock      sync.Mutex
+	currIndex int
+}
+
+func (po *LeastConnPolicy) Get(ctx context.Context) (*sqlx.DB, error) {
+	po.lock.Lock()
+	defer po.lock.Unlock()
+	if len(po.dbs) == 0 {
+		return nil, ErrNoDBPool
+	}
+
+	// start from a rotating offset so ties are spread over the pool
+	if po.currIndex >= len(po.dbs) {
+		po.currIndex = 0
+	}
+	var (
+		best   *sqlx.DB
+		bestIn int
+	)
+	for i := 0; i < len(po.dbs); i++ {
+		db := po.dbs[(po.currIndex+i)%len(po.dbs)]
+		if !NodeAllowed(ctx, db) {
+			continue
+		}
+		inUse := db.Stats().InUse
+		if best == nil || inUse < bestIn {
+			best, bestIn = db, inUse
+		}
+	}
+	po.currIndex++
+	if best == nil {
+		return nil, ErrNoHealthyNode
+	}
+	return best, nil
+}
+
+func (po *LeastConnPolicy) Nodes() []*sqlx.DB {
+	return copyNodes(po.dbs)
+}
+
+func copyNodes(dbs []*sqlx.DB) []*sqlx.DB {
+	nodes := make([]*sqlx.DB, len(dbs))
+	copy(nodes, dbs)
+	return nodes
+}
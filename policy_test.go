@@ -0,0 +1,82 @@
+package sqlxx
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWeightedPolicy_Distribution(t *testing.T) {
+	a, b := &sqlx.DB{}, &sqlx.DB{}
+	po, err := NewWeightedPolicy([]*sqlx.DB{a, b}, []int{1, 3})
+	require.NoError(t, err)
+
+	counts := map[*sqlx.DB]int{}
+	for i := 0; i < 400; i++ {
+		db, err := po.Get(context.Background())
+		require.NoError(t, err)
+		counts[db]++
+	}
+	assert.Equal(t, 100, counts[a])
+	assert.Equal(t, 300, counts[b])
+
+	// smooth: a is never picked twice in a row
+	po, _ = NewWeightedPolicy([]*sqlx.DB{a, b}, []int{1, 3})
+	var prev *sqlx.DB
+	for i := 0; i < 40; i++ {
+		db, _ := po.Get(context.Background())
+		assert.False(t, db == a && prev == a)
+		prev = db
+	}
+}
+
+func TestWeightedPolicy_Filter(t *testing.T) {
+	a, b, c := &sqlx.DB{}, &sqlx.DB{}, &sqlx.DB{}
+	po, err := NewWeightedPolicy([]*sqlx.DB{a, b, c}, []int{5, 1, 1})
+	require.NoError(t, err)
+
+	ctx := WithNodeFilter(context.Background(), func(db *sqlx.DB) bool { return db != a })
+	counts := map[*sqlx.DB]int{}
+	for i := 0; i < 100; i++ {
+		db, err := po.Get(ctx)
+		require.NoError(t, err)
+		counts[db]++
+	}
+	assert.Equal(t, 0, counts[a])
+	assert.Equal(t, 50, counts[b])
+	assert.Equal(t, 50, counts[c])
+
+	ctx = WithNodeFilter(context.Background(), func(db *sqlx.DB) bool { return false })
+	_, err = po.Get(ctx)
+	assert.ErrorIs(t, err, ErrNoHealthyNode)
+
+	_, err = NewWeightedPolicy([]*sqlx.DB{a}, []int{0})
+	assert.Error(t, err)
+}
+
+func TestLeastConnPolicy(t *testing.T) {
+	busy, _ := newFakeDB("busy", "mysql")
+	idle, _ := newFakeDB("idle", "mysql")
+	ctx := context.Background()
+	conn, err := busy.Connx(ctx)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	po := NewLeastConnPolicy([]*sqlx.DB{busy, idle})
+	for i := 0; i < 4; i++ {
+		db, err := po.Get(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, idle, db)
+	}
+
+	ctx = WithNodeFilter(ctx, func(db *sqlx.DB) bool { return db != idle })
+	db, err := po.Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, busy, db)
+
+	_, err = NewLeastConnPolicy(nil).Get(ctx)
+	assert.ErrorIs(t, err, ErrNoDBPool)
+}
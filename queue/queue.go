@@ -0,0 +1,234 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/vx416/sqlxx"
+	"github.com/vx416/sqlxx/builder"
+	"github.com/vx416/sqlxx/internal/backoff"
+	"gopkg.in/guregu/null.v4"
+)
+
+const (
+	StatusReady = "ready"
+	StatusDone  = "done"
+	StatusDead  = "dead"
+)
+
+// ErrLostJob is returned when a job is dequeued again after its visibility
+// timeout before it is completed or failed.
+var ErrLostJob = errors.New("job is lost")
+
+// Job is a row of the job table, the table looks like
+//
+//	CREATE TABLE jobs (
+//		id           BIGINT AUTO_INCREMENT PRIMARY KEY,
+//		queue        VARCHAR(255) NOT NULL,
+//		payload      BLOB NOT NULL,
+//		status       VARCHAR(16) NOT NULL DEFAULT 'ready',
+//		attempts     INT NOT NULL DEFAULT 0,
+//		max_attempts INT NOT NULL,
+//		last_error   TEXT NULL,
+//		run_at       DATETIME(6) NOT NULL,
+//		created_at   DATETIME(6) NOT NULL,
+//		INDEX idx_jobs_queue (queue, status, run_at)
+//	)
+//
+// A dequeued job is hidden until run_at, which is moved forward by the
+// visibility timeout, the job is dequeued again if it is not completed or failed
+// in time.
+type Job struct {
+	ID          int64       `db:"id"`
+	Queue       string      `db:"queue"`
+	Payload     []byte      `db:"payload"`
+	Status      string      `db:"status"`
+	Attempts    int         `db:"attempts"`
+	MaxAttempts int         `db:"max_attempts"`
+	LastError   null.String `db:"last_error"`
+	RunAt       time.Time   `db:"run_at"`
+	CreatedAt   time.Time   `db:"created_at"`
+}
+
+type Config struct {
+	// Table is the job table, default is jobs
+	Table string
+	// VisibilityTimeout is how long a dequeued job is hidden, default is 30s
+	VisibilityTimeout time.Duration
+	// MaxAttempts is the attempts of a job without MaxAttempts, default is 5
+	MaxAttempts int
+	// Backoff is the wait before the first retry, it doubles with every attempt up
+	// to MaxBackoff, default is 1s and 10m
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+func (cfg *Config) setDefault() {
+	if cfg.Table == "" {
+		cfg.Table = "jobs"
+	}
+	if cfg.VisibilityTimeout <= 0 {
+		cfg.VisibilityTimeout = 30 * time.Second
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 5
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = time.Second
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 10 * time.Minute
+	}
+}
+
+func New(db *sqlxx.Sqlxx, cfg Config) *Queue {
+	cfg.setDefault()
+	return &Queue{
+		db:  db,
+		cfg: cfg,
+	}
+}
+
+type Queue struct {
+	db  *sqlxx.Sqlxx
+	cfg Config
+}
+
+// Enqueue adds jobs in the tx of ctx, or in a new tx if ctx has none. A job runs
+// at once unless RunAt is set.
+func (q *Queue) Enqueue(ctx context.Context, jobs ...*Job) error {
+	return q.db.ExecuteTx(ctx, func(txCtx context.Context) error {
+		now := time.Now()
+		for _, job := range jobs {
+			job.Status = StatusReady
+			job.CreatedAt = now
+			if job.RunAt.IsZero() {
+				job.RunAt = now
+			}
+			if job.MaxAttempts <= 0 {
+				job.MaxAttempts = q.cfg.MaxAttempts
+			}
+			res, err := q.db.GetDB(txCtx).Exec(txCtx, builder.Insert().Table(q.cfg.Table).InsertRows(job))
+			if err != nil {
+				return err
+			}
+			if id, err := res.LastInsertId(); err == nil {
+				job.ID = id
+			}
+		}
+		return nil
+	}, sqlxx.WithPropagation(sqlxx.PropagationRequired))
+}
+
+// Dequeue claims up to n ready jobs of queue, the jobs are hidden for the
+// visibility timeout and must be completed or failed. Jobs out of attempts are
+// dead instead of returned. Nothing is claimed if n <= 0.
+func (q *Queue) Dequeue(ctx context.Context, queue string, n int) ([]*Job, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	var claimed []*Job
+	err := q.db.ExecuteTx(ctx, func(txCtx context.Context) error {
+		now := time.Now()
+		query := builder.Query().Select("*").From(q.cfg.Table).
+			And("queue = ?", queue).
+			And("status = ?", StatusReady).
+			And("run_at <= ?", now).
+			OrderBy("run_at", "id").
+			LimitOffset(n, 0).
+			SkipLocked()
+		jobs := make([]*Job, 0, n)
+		if err := q.db.GetDB(txCtx).Select(txCtx, &jobs, query); err != nil {
+			return err
+		}
+
+		claimed = make([]*Job, 0, len(jobs))
+		claimedIDs := make([]int64, 0, len(jobs))
+		deadIDs := make([]int64, 0)
+		for _, job := range jobs {
+			if job.Attempts >= job.MaxAttempts {
+				deadIDs = append(deadIDs, job.ID)
+				continue
+			}
+			job.Attempts++
+			job.RunAt = now.Add(q.cfg.VisibilityTimeout)
+			claimed = append(claimed, job)
+			claimedIDs = append(claimedIDs, job.ID)
+		}
+
+		if len(claimedIDs) > 0 {
+			update := builder.Update().Table(q.cfg.Table).
+				Set("attempts = attempts + 1", nil).
+				Set("run_at = ?", now.Add(q.cfg.VisibilityTimeout)).
+				AndIn("id IN (?)", claimedIDs)
+			if _, err := q.db.GetDB(txCtx).Exec(txCtx, update); err != nil {
+				return err
+			}
+		}
+		if len(deadIDs) > 0 {
+			update := builder.Update().Table(q.cfg.Table).
+				Set("status = ?", StatusDead).
+				Set("last_error = ?", "visibility timeout expired on the last attempt").
+				AndIn("id IN (?)", deadIDs)
+			if _, err := q.db.GetDB(txCtx).Exec(txCtx, update); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+// Complete marks job as done, it should be called in the tx of the work of job.
+func (q *Queue) Complete(ctx context.Context, job *Job) error {
+	update := builder.Update().Table(q.cfg.Table).
+		Set("status = ?", StatusDone)
+	if err := q.claimedExec(ctx, job, update); err != nil {
+		return err
+	}
+	job.Status = StatusDone
+	return nil
+}
+
+// Fail schedules the retry of job with backoff, job is dead if it is out of
+// attempts.
+func (q *Queue) Fail(ctx context.Context, job *Job, jobErr error) error {
+	lastError := null.StringFrom(jobErr.Error())
+	update := builder.Update().Table(q.cfg.Table).
+		Set("last_error = ?", lastError)
+	status, runAt := job.Status, job.RunAt
+	if job.Attempts >= job.MaxAttempts {
+		status = StatusDead
+		update.Set("status = ?", status)
+	} else {
+		runAt = time.Now().Add(backoff.Exponential(q.cfg.Backoff, q.cfg.MaxBackoff, job.Attempts))
+		update.Set("run_at = ?", runAt)
+	}
+	if err := q.claimedExec(ctx, job, update); err != nil {
+		return err
+	}
+	job.LastError, job.Status, job.RunAt = lastError, status, runAt
+	return nil
+}
+
+// claimedExec updates job only if it is not dequeued again, the attempts of
+// job tell the claims apart.
+func (q *Queue) claimedExec(ctx context.Context, job *Job, update *builder.UpdateBuilder) error {
+	update.And("id = ?", job.ID).And("attempts = ?", job.Attempts).And("status = ?", StatusReady)
+	res, err := q.db.GetDB(ctx).Exec(ctx, update)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrLostJob
+	}
+	return nil
+}
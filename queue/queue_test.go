@@ -0,0 +1,217 @@
+package queue
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vx416/sqlxx"
+	"github.com/vx416/sqlxx/internal/fakedb"
+)
+
+var jobColumns = []string{"id", "queue", "payload", "status", "attempts", "max_attempts", "last_error", "run_at", "created_at"}
+
+func jobRow(id int64, attempts, maxAttempts int) []driver.Value {
+	now := time.Now()
+	return []driver.Value{id, "mail", []byte("payload"), StatusReady, int64(attempts), int64(maxAttempts), nil, now, now}
+}
+
+func TestQueue_Enqueue(t *testing.T) {
+	db, node := fakedb.New("master", "mysql")
+	adapter := sqlxx.NewWith(db)
+	q := New(adapter, Config{})
+	ctx := context.Background()
+
+	job := &Job{Queue: "mail", Payload: []byte("payload")}
+	require.NoError(t, q.Enqueue(ctx, job))
+	assert.Equal(t, StatusReady, job.Status)
+	assert.Equal(t, 5, job.MaxAttempts)
+	assert.False(t, job.RunAt.IsZero())
+	log := node.Log()
+	require.Len(t, log, 3)
+	assert.Equal(t, "BEGIN", log[0])
+	assert.True(t, strings.HasPrefix(log[1], "INSERT INTO jobs "))
+	assert.Equal(t, "COMMIT", log[2])
+
+	// the jobs are added in the tx of ctx
+	db, node = fakedb.New("master", "mysql")
+	adapter = sqlxx.NewWith(db)
+	q = New(adapter, Config{})
+	err := adapter.ExecuteTx(ctx, func(txCtx context.Context) error {
+		if err := q.Enqueue(txCtx, &Job{Queue: "mail"}, &Job{Queue: "mail"}); err != nil {
+			return err
+		}
+		return errors.New("abort")
+	})
+	require.Error(t, err)
+	log = node.Log()
+	require.Len(t, log, 4)
+	assert.Equal(t, "BEGIN", log[0])
+	assert.True(t, strings.HasPrefix(log[1], "INSERT INTO jobs "))
+	assert.True(t, strings.HasPrefix(log[2], "INSERT INTO jobs "))
+	assert.Equal(t, "ROLLBACK", log[3])
+}
+
+func TestQueue_Dequeue(t *testing.T) {
+	db, node := fakedb.New("master", "mysql")
+	node.Rows = func(query string) ([]string, [][]driver.Value) {
+		return jobColumns, [][]driver.Value{jobRow(1, 0, 3), jobRow(2, 1, 3)}
+	}
+	q := New(sqlxx.NewWith(db), Config{VisibilityTimeout: time.Minute})
+	ctx := context.Background()
+
+	before := time.Now()
+	jobs, err := q.Dequeue(ctx, "mail", 2)
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+	assert.Equal(t, 1, jobs[0].Attempts)
+	assert.Equal(t, 2, jobs[1].Attempts)
+	assert.True(t, jobs[0].RunAt.After(before.Add(59*time.Second)))
+
+	log, args := node.Log(), node.ArgLog()
+	require.Len(t, log, 4)
+	assert.Equal(t, "SELECT * FROM jobs WHERE queue = ? AND status = ? AND run_at <= ? ORDER BY run_at, id LIMIT 2 FOR UPDATE SKIP LOCKED", log[1])
+	assert.Equal(t, "UPDATE jobs SET attempts = attempts + 1, run_at = ? WHERE id IN (?, ?)", log[2])
+	assert.Equal(t, []driver.Value{int64(1), int64(2)}, args[2][1:])
+	assert.Equal(t, "COMMIT", log[3])
+}
+
+func TestQueue_DequeueDead(t *testing.T) {
+	db, node := fakedb.New("master", "mysql")
+	node.Rows = func(query string) ([]string, [][]driver.Value) {
+		return jobColumns, [][]driver.Value{jobRow(1, 3, 3), jobRow(2, 0, 3)}
+	}
+	q := New(sqlxx.NewWith(db), Config{})
+
+	jobs, err := q.Dequeue(context.Background(), "mail", 2)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, int64(2), jobs[0].ID)
+
+	log, args := node.Log(), node.ArgLog()
+	require.Len(t, log, 5)
+	assert.Equal(t, "UPDATE jobs SET attempts = attempts + 1, run_at = ? WHERE id IN (?)", log[2])
+	assert.Equal(t, int64(2), args[2][1])
+	assert.Equal(t, "UPDATE jobs SET status = ?, last_error = ? WHERE id IN (?)", log[3])
+	assert.Equal(t, []driver.Value{StatusDead, "visibility timeout expired on the last attempt", int64(1)}, args[3])
+}
+
+func TestQueue_DequeueNone(t *testing.T) {
+	db, node := fakedb.New("master", "mysql")
+	q := New(sqlxx.NewWith(db), Config{})
+
+	jobs, err := q.Dequeue(context.Background(), "mail", 0)
+	assert.NoError(t, err)
+	assert.Empty(t, jobs)
+	assert.Empty(t, node.Log())
+}
+
+func TestQueue_CompleteFail(t *testing.T) {
+	db, node := fakedb.New("master", "mysql")
+	var affected int64 = 1
+	node.Affected = func(query string) int64 {
+		return atomic.LoadInt64(&affected)
+	}
+	q := New(sqlxx.NewWith(db), Config{Backoff: time.Second, MaxBackoff: 3 * time.Second})
+	ctx := context.Background()
+
+	job := &Job{ID: 1, Status: StatusReady, Attempts: 1, MaxAttempts: 3}
+	require.NoError(t, q.Complete(ctx, job))
+	assert.Equal(t, StatusDone, job.Status)
+	assert.Equal(t, "UPDATE jobs SET status = ? WHERE id = ? AND attempts = ? AND status = ?", node.Log()[0])
+	assert.Equal(t, []driver.Value{StatusDone, int64(1), int64(1), StatusReady}, node.ArgLog()[0])
+
+	// the retry backoff doubles up to MaxBackoff
+	job = &Job{ID: 2, Status: StatusReady, Attempts: 2, MaxAttempts: 3}
+	before := time.Now()
+	require.NoError(t, q.Fail(ctx, job, errors.New("boom")))
+	assert.Equal(t, StatusReady, job.Status)
+	assert.Equal(t, "boom", job.LastError.String)
+	assert.WithinDuration(t, before.Add(2*time.Second), job.RunAt, time.Second)
+	assert.Equal(t, "UPDATE jobs SET last_error = ?, run_at = ? WHERE id = ? AND attempts = ? AND status = ?", node.Log()[1])
+
+	// the job is dead on the last attempt
+	job = &Job{ID: 3, Status: StatusReady, Attempts: 3, MaxAttempts: 3}
+	require.NoError(t, q.Fail(ctx, job, errors.New("boom")))
+	assert.Equal(t, StatusDead, job.Status)
+	assert.Equal(t, "UPDATE jobs SET last_error = ?, status = ? WHERE id = ? AND attempts = ? AND status = ?", node.Log()[2])
+
+	// the job is dequeued again by others
+	atomic.StoreInt64(&affected, 0)
+	job = &Job{ID: 4, Status: StatusReady, Attempts: 1, MaxAttempts: 3}
+	assert.ErrorIs(t, q.Complete(ctx, job), ErrLostJob)
+	assert.Equal(t, StatusReady, job.Status)
+	assert.ErrorIs(t, q.Fail(ctx, job, errors.New("boom")), ErrLostJob)
+	assert.False(t, job.LastError.Valid)
+}
+
+func TestWorker_Concurrency(t *testing.T) {
+	db, node := fakedb.New("master", "mysql")
+	const total = 6
+	var nextID int64
+	node.Rows = func(query string) ([]string, [][]driver.Value) {
+		var limit int64
+		fmt.Sscanf(query[strings.Index(query, "LIMIT "):], "LIMIT %d", &limit)
+		var rows [][]driver.Value
+		for i := int64(0); i < limit; i++ {
+			id := atomic.AddInt64(&nextID, 1)
+			if id > total {
+				break
+			}
+			rows = append(rows, jobRow(id, 0, 3))
+		}
+		return jobColumns, rows
+	}
+	q := New(sqlxx.NewWith(db), Config{})
+
+	var (
+		lock      sync.Mutex
+		running   int
+		maxActive int
+	)
+	handler := HandlerFunc(func(ctx context.Context, job *Job) error {
+		lock.Lock()
+		running++
+		if running > maxActive {
+			maxActive = running
+		}
+		lock.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		lock.Lock()
+		running--
+		lock.Unlock()
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- q.Worker("mail", handler, WorkerConfig{Concurrency: 2, PollInterval: time.Millisecond}).Run(ctx)
+	}()
+
+	completed := func() int {
+		n := 0
+		for _, query := range node.Log() {
+			if strings.HasPrefix(query, "UPDATE jobs SET status = ?") {
+				n++
+			}
+		}
+		return n
+	}
+	assert.Eventually(t, func() bool {
+		return completed() == total
+	}, time.Second, time.Millisecond)
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, 2, maxActive)
+}
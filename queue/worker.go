@@ -0,0 +1,127 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/vx416/sqlxx"
+)
+
+type Handler interface {
+	Handle(ctx context.Context, job *Job) error
+}
+
+type HandlerFunc func(ctx context.Context, job *Job) error
+
+func (f HandlerFunc) Handle(ctx context.Context, job *Job) error {
+	return f(ctx, job)
+}
+
+type WorkerConfig struct {
+	// Concurrency is the jobs handled at the same time, default is 1
+	Concurrency int
+	// PollInterval is the wait between polls when the queue is empty, default is 1s
+	PollInterval time.Duration
+	// OnError is called with the errors of the worker
+	OnError func(err error)
+}
+
+func (cfg *WorkerConfig) setDefault() {
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+}
+
+// Worker runs handler on the jobs of queue, a job is completed in the same tx as
+// the handler, so the writes of the handler and the completion commit together.
+func (q *Queue) Worker(queue string, handler Handler, cfg WorkerConfig) *Worker {
+	cfg.setDefault()
+	return &Worker{
+		queue:   q,
+		name:    queue,
+		handler: handler,
+		cfg:     cfg,
+	}
+}
+
+type Worker struct {
+	queue   *Queue
+	name    string
+	handler Handler
+	cfg     WorkerConfig
+}
+
+// Run handles jobs until ctx is done, it returns after the running handlers.
+func (w *Worker) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	slots := make(chan struct{}, w.cfg.Concurrency)
+	for {
+		free := cap(slots) - len(slots)
+		var jobs []*Job
+		if free > 0 {
+			var err error
+			jobs, err = w.queue.Dequeue(ctx, w.name, free)
+			if err != nil {
+				w.onError(err)
+			}
+		}
+		for _, job := range jobs {
+			slots <- struct{}{}
+			wg.Add(1)
+			go func(job *Job) {
+				defer wg.Done()
+				defer func() {
+					<-slots
+				}()
+				w.handle(ctx, job)
+			}(job)
+		}
+		if free > 0 && len(jobs) == free {
+			continue
+		}
+
+		timer := time.NewTimer(w.cfg.PollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (w *Worker) handle(ctx context.Context, job *Job) {
+	err := w.queue.db.ExecuteTx(ctx, func(txCtx context.Context) error {
+		if err := w.handler.Handle(txCtx, job); err != nil {
+			return err
+		}
+		return w.queue.Complete(txCtx, job)
+	}, sqlxx.WithPropagation(sqlxx.PropagationRequiresNew), sqlxx.WithPanicError())
+	if err == nil {
+		return
+	}
+	if errors.Is(err, ErrLostJob) {
+		w.onError(err)
+		return
+	}
+	var txErr *sqlxx.TxError
+	if errors.As(err, &txErr) && txErr.Callback != nil {
+		err = txErr.Callback
+	}
+	if failErr := w.queue.Fail(ctx, job, err); failErr != nil {
+		w.onError(failErr)
+	}
+}
+
+func (w *Worker) onError(err error) {
+	if w.cfg.OnError != nil {
+		w.cfg.OnError(err)
+	}
+}
@@ -0,0 +1,80 @@
+package sqlxx
+
+import (
+	"fmt"
+	"sync"
+)
+
+var defaultRegistry = NewRegistry()
+
+// Register registers a database named name on the default registry.
+func Register(name string, cluster *Cluster) *Sqlxx {
+	return defaultRegistry.Register(name, cluster)
+}
+
+// RegisterAdapter registers adapter as name on the default registry.
+func RegisterAdapter(name string, adapter *Sqlxx) *Sqlxx {
+	return defaultRegistry.RegisterAdapter(name, adapter)
+}
+
+// Use returns the database registered as name on the default registry, it panics
+// if name is not registered.
+func Use(name string) *Sqlxx {
+	return defaultRegistry.Use(name)
+}
+
+func Lookup(name string) (*Sqlxx, bool) {
+	return defaultRegistry.Lookup(name)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		dbs: make(map[string]*Sqlxx),
+	}
+}
+
+// Registry holds named databases, the transactions of each database are kept in
+// the context under a key scoped by its name.
+type Registry struct {
+	lock sync.RWMutex
+	dbs  map[string]*Sqlxx
+}
+
+func (r *Registry) Register(name string, cluster *Cluster) *Sqlxx {
+	return r.RegisterAdapter(name, New(cluster))
+}
+
+func (r *Registry) RegisterAdapter(name string, adapter *Sqlxx) *Sqlxx {
+	adapter.name = name
+	adapter.registry = r
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.dbs[name] = adapter
+	return adapter
+}
+
+func (r *Registry) Use(name string) *Sqlxx {
+	adapter, ok := r.Lookup(name)
+	if !ok {
+		panic(fmt.Sprintf("sqlxx: database(%s) is not registered", name))
+	}
+	return adapter
+}
+
+func (r *Registry) Lookup(name string) (*Sqlxx, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	adapter, ok := r.dbs[name]
+	return adapter, ok
+}
+
+// Close closes every registered database.
+func (r *Registry) Close() error {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for _, adapter := range r.dbs {
+		adapter.Close()
+	}
+	return nil
+}
@@ -0,0 +1,395 @@
+package sqlxx
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx/reflectx"
+	"github.com/vx416/sqlxx/builder"
+)
+
+type ShardError struct {
+	Shard int
+	Err   error
+}
+
+func (e *ShardError) Error() string {
+	return fmt.Sprintf("shard(%d): %v", e.Shard, e.Err)
+}
+
+func (e *ShardError) Unwrap() error {
+	return e.Err
+}
+
+// ScatterError is returned when a scatter query failed on part of the shards,
+// the rows or count of the other shards are still returned.
+type ScatterError struct {
+	Shards int
+	Errors []*ShardError
+}
+
+func (e *ScatterError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("scatter query failed on %d/%d shards: %s", len(e.Errors), e.Shards, strings.Join(msgs, "; "))
+}
+
+var (
+	ErrScatterGrouped = errors.New("scatter query does not support GROUP BY, DISTINCT or aggregate functions")
+
+	sortMapper   = reflectx.NewMapperFunc("db", strings.ToLower)
+	distinctExp  = regexp.MustCompile(`(?i)\bDISTINCT\b`)
+	aggregateExp = regexp.MustCompile(`(?i)\b(COUNT|SUM|AVG|MIN|MAX|GROUP_CONCAT|STRING_AGG|ARRAY_AGG|JSON_ARRAYAGG|BIT_AND|BIT_OR)\s*\(`)
+)
+
+// SelectAll runs query on every shard in parallel, the rows are concatenated and
+// the ORDER BY and LIMIT/OFFSET of query are re-applied in memory. Grouped,
+// distinct and aggregated queries can not be merged and return ErrScatterGrouped.
+func (db *DB) SelectAll(ctx context.Context, dest interface{}, query *builder.QueryBuilder) error {
+	if len(query.GetGroupBy()) > 0 || distinctExp.MatchString(query.GetSelect()) || aggregateExp.MatchString(query.GetSelect()) {
+		return ErrScatterGrouped
+	}
+	if db.Tx != nil {
+		return db.Select(ctx, dest, query)
+	}
+	destVal := reflect.ValueOf(dest)
+	if destVal.Kind() != reflect.Ptr || destVal.Elem().Kind() != reflect.Slice {
+		return errors.New("dest should be pointer of slice")
+	}
+	shards, err := db.getShards()
+	if err != nil {
+		return err
+	}
+
+	limit, offset := query.GetLimitOffset()
+	shardQuery := query.Clone()
+	if limit > 0 {
+		shardQuery.LimitOffset(limit+offset, 0)
+	} else {
+		shardQuery.LimitOffset(0, 0)
+	}
+	queryS, args, err := shardQuery.Build()
+	if err != nil {
+		return err
+	}
+
+	sliceType := destVal.Elem().Type()
+	results := make([]reflect.Value, len(shards))
+	scatterErr := db.scatter(shards, func(i int, shardDB *DB) error {
+		rows := reflect.New(sliceType)
+		if err := shardDB.SelectContext(ctx, rows.Interface(), queryS, args...); err != nil {
+			return err
+		}
+		results[i] = rows.Elem()
+		return nil
+	})
+
+	merged := reflect.MakeSlice(sliceType, 0, 10)
+	for _, rows := range results {
+		if rows.IsValid() {
+			merged = reflect.AppendSlice(merged, rows)
+		}
+	}
+	if err := sortRows(merged, query.GetOrderBy()); err != nil {
+		return err
+	}
+	if offset > merged.Len() {
+		offset = merged.Len()
+	}
+	merged = merged.Slice(offset, merged.Len())
+	if limit > 0 && limit < merged.Len() {
+		merged = merged.Slice(0, limit)
+	}
+	destVal.Elem().Set(merged)
+
+	if scatterErr != nil {
+		return scatterErr
+	}
+	return nil
+}
+
+// CountAll runs query.Count() on every shard in parallel and sums the result,
+// grouped and distinct queries return ErrScatterGrouped.
+func (db *DB) CountAll(ctx context.Context, query *builder.QueryBuilder) (int64, error) {
+	if len(query.GetGroupBy()) > 0 || distinctExp.MatchString(query.GetSelect()) {
+		return 0, ErrScatterGrouped
+	}
+	countQuery := query.Clone().Count().ClearOrderBy().LimitOffset(0, 0)
+	if db.Tx != nil {
+		var count int64
+		err := db.Get(ctx, &count, countQuery)
+		return count, err
+	}
+	shards, err := db.getShards()
+	if err != nil {
+		return 0, err
+	}
+
+	queryS, args, err := countQuery.Build()
+	if err != nil {
+		return 0, err
+	}
+
+	counts := make([]int64, len(shards))
+	scatterErr := db.scatter(shards, func(i int, shardDB *DB) error {
+		return shardDB.GetContext(ctx, &counts[i], queryS, args...)
+	})
+
+	var total int64
+	for _, count := range counts {
+		total += count
+	}
+	if scatterErr != nil {
+		return total, scatterErr
+	}
+	return total, nil
+}
+
+func (db *DB) getShards() ([]*Cluster, error) {
+	if sharded, ok := db.resolver.(*ShardedCluster); ok {
+		return sharded.Shards(), nil
+	}
+	if db.Cluster == nil {
+		return nil, ErrNoDBPool
+	}
+	return []*Cluster{db.Cluster}, nil
+}
+
+func (db *DB) scatter(shards []*Cluster, fn func(i int, shardDB *DB) error) *ScatterError {
+	var (
+		wg   = sync.WaitGroup{}
+		lock = sync.Mutex{}
+		errs = make([]*ShardError, 0, len(shards))
+	)
+	for i, shard := range shards {
+		wg.Add(1)
+		go func(i int, shard *Cluster) {
+			defer wg.Done()
+			if err := fn(i, &DB{Cluster: shard}); err != nil {
+				lock.Lock()
+				errs = append(errs, &ShardError{Shard: i, Err: err})
+				lock.Unlock()
+			}
+		}(i, shard)
+	}
+	wg.Wait()
+
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Shard < errs[j].Shard
+	})
+	return &ScatterError{Shards: len(shards), Errors: errs}
+}
+
+type sortKey struct {
+	col  string
+	desc bool
+}
+
+func parseOrderBy(orders []string) []sortKey {
+	keys := make([]sortKey, 0, len(orders))
+	for _, order := range orders {
+		for _, item := range strings.Split(order, ",") {
+			fields := strings.Fields(item)
+			if len(fields) == 0 {
+				continue
+			}
+			col := fields[0]
+			if idx := strings.LastIndex(col, "."); idx >= 0 {
+				col = col[idx+1:]
+			}
+			key := sortKey{col: strings.Trim(col, "`\"")}
+			if len(fields) > 1 && strings.EqualFold(fields[1], "DESC") {
+				key.desc = true
+			}
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func sortRows(rows reflect.Value, orders []string) error {
+	keys := parseOrderBy(orders)
+	if len(keys) == 0 || rows.Len() < 2 {
+		return nil
+	}
+
+	elemType := reflectx.Deref(rows.Type().Elem())
+	indexes := make([][]int, len(keys))
+	if elemType.Kind() == reflect.Struct {
+		fields := sortMapper.TypeMap(elemType)
+		for j, key := range keys {
+			field := fields.GetByPath(key.col)
+			if field == nil {
+				return fmt.Errorf("order by column(%s) is not found in %s", key.col, elemType)
+			}
+			indexes[j] = field.Index
+		}
+	}
+
+	values := make([][]interface{}, rows.Len())
+	for i := range values {
+		values[i] = make([]interface{}, len(keys))
+		for j := range keys {
+			values[i][j] = columnValue(rows.Index(i), indexes[j])
+		}
+	}
+	swap := reflect.Swapper(rows.Interface())
+	sort.Stable(rowSorter{values: values, keys: keys, swap: swap})
+	return nil
+}
+
+// columnValue returns the field of row at index without allocating nil pointers,
+// nil is returned if a pointer on the path is nil.
+func columnValue(row reflect.Value, index []int) interface{} {
+	for _, i := range index {
+		for row.Kind() == reflect.Ptr {
+			if row.IsNil() {
+				return nil
+			}
+			row = row.Elem()
+		}
+		row = row.Field(i)
+	}
+	if row.Kind() == reflect.Ptr && row.IsNil() {
+		return nil
+	}
+	return row.Interface()
+}
+
+type rowSorter struct {
+	values [][]interface{}
+	keys   []sortKey
+	swap   func(i, j int)
+}
+
+func (s rowSorter) Len() int {
+	return len(s.values)
+}
+
+func (s rowSorter) Swap(i, j int) {
+	s.values[i], s.values[j] = s.values[j], s.values[i]
+	s.swap(i, j)
+}
+
+func (s rowSorter) Less(i, j int) bool {
+	for k, key := range s.keys {
+		c := compareValues(s.values[i][k], s.values[j][k])
+		if c == 0 {
+			continue
+		}
+		if key.desc {
+			return c > 0
+		}
+		return c < 0
+	}
+	return false
+}
+
+// compareValues compares two column values, null values are greater than the
+// others so they are ordered last in ASC and first in DESC like Postgres.
+func compareValues(a, b interface{}) int {
+	a, b = sortValue(a), sortValue(b)
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+
+	switch av := a.(type) {
+	case int64:
+		if bv, ok := b.(int64); ok {
+			return compareOrdered(av < bv, av > bv)
+		}
+	case uint64:
+		if bv, ok := b.(uint64); ok {
+			return compareOrdered(av < bv, av > bv)
+		}
+	case float64:
+		if bv, ok := b.(float64); ok {
+			return compareOrdered(av < bv, av > bv)
+		}
+	case string:
+		if bv, ok := b.(string); ok {
+			return strings.Compare(av, bv)
+		}
+	case bool:
+		if bv, ok := b.(bool); ok {
+			return compareOrdered(!av && bv, av && !bv)
+		}
+	case time.Time:
+		if bv, ok := b.(time.Time); ok {
+			return compareOrdered(av.Before(bv), av.After(bv))
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func compareOrdered(less, greater bool) int {
+	if less {
+		return -1
+	}
+	if greater {
+		return 1
+	}
+	return 0
+}
+
+func sortValue(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	if t, ok := v.(time.Time); ok {
+		return t
+	}
+	if valuer, ok := v.(driver.Valuer); ok {
+		rv := reflect.ValueOf(valuer)
+		if rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return nil
+		}
+		val, err := valuer.Value()
+		if err != nil {
+			return nil
+		}
+		return sortValue(val)
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return nil
+		}
+		return sortValue(rv.Elem().Interface())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return rv.Uint()
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.String:
+		return rv.String()
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.Slice:
+		if b, ok := v.([]byte); ok {
+			return string(b)
+		}
+	}
+	return v
+}
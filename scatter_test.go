@@ -0,0 +1,168 @@
+package sqlxx
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vx416/sqlxx/builder"
+	"gopkg.in/guregu/null.v4"
+)
+
+type scatterRow struct {
+	ID    int64       `db:"id"`
+	Score *int64      `db:"score"`
+	Name  null.String `db:"name"`
+}
+
+func int64Ptr(i int64) *int64 {
+	return &i
+}
+
+func TestParseOrderBy(t *testing.T) {
+	keys := parseOrderBy([]string{"u.score DESC, `name`", "id asc", " "})
+	assert.Equal(t, []sortKey{
+		{col: "score", desc: true},
+		{col: "name"},
+		{col: "id"},
+	}, keys)
+}
+
+func TestCompareValues(t *testing.T) {
+	now := time.Now()
+	testCases := []struct {
+		a, b interface{}
+		want int
+	}{
+		{int64(1), int64(2), -1},
+		{uint8(3), uint8(3), 0},
+		{2.5, 1.5, 1},
+		{"a", "b", -1},
+		{false, true, -1},
+		{now, now.Add(time.Second), -1},
+		{int64Ptr(2), int64Ptr(1), 1},
+		{null.StringFrom("a"), null.StringFrom("b"), -1},
+		{null.IntFrom(1), null.IntFrom(1), 0},
+		{[]byte("b"), []byte("a"), 1},
+		// nulls are greater than any value
+		{nil, int64(1), 1},
+		{int64(1), (*int64)(nil), -1},
+		{null.String{}, null.StringFrom("a"), 1},
+		{null.String{}, (*int64)(nil), 0},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.want, compareValues(tc.a, tc.b), "%v %v", tc.a, tc.b)
+	}
+}
+
+func TestSortRows(t *testing.T) {
+	rows := []scatterRow{
+		{ID: 1, Score: int64Ptr(10), Name: null.StringFrom("b")},
+		{ID: 2, Score: nil, Name: null.StringFrom("a")},
+		{ID: 3, Score: int64Ptr(10), Name: null.StringFrom("a")},
+		{ID: 4, Score: int64Ptr(20), Name: null.String{}},
+		{ID: 5, Score: int64Ptr(20), Name: null.StringFrom("c")},
+	}
+	ids := func(rows []scatterRow) []int64 {
+		res := make([]int64, 0, len(rows))
+		for _, row := range rows {
+			res = append(res, row.ID)
+		}
+		return res
+	}
+
+	asc := append([]scatterRow(nil), rows...)
+	require.NoError(t, sortRows(reflect.ValueOf(asc), []string{"score", "name"}))
+	assert.Equal(t, []int64{3, 1, 5, 4, 2}, ids(asc))
+
+	desc := append([]scatterRow(nil), rows...)
+	require.NoError(t, sortRows(reflect.ValueOf(desc), []string{"score DESC, name ASC"}))
+	assert.Equal(t, []int64{2, 5, 4, 3, 1}, ids(desc))
+	assert.Nil(t, desc[0].Score, "nil pointers are not allocated")
+
+	ptrs := []*scatterRow{&rows[0], &rows[1], &rows[4]}
+	require.NoError(t, sortRows(reflect.ValueOf(ptrs), []string{"name DESC"}))
+	assert.Equal(t, []int64{5, 1, 2}, []int64{ptrs[0].ID, ptrs[1].ID, ptrs[2].ID})
+
+	scalars := []int64{3, 1, 2}
+	require.NoError(t, sortRows(reflect.ValueOf(scalars), []string{"id DESC"}))
+	assert.Equal(t, []int64{3, 2, 1}, scalars)
+
+	assert.Error(t, sortRows(reflect.ValueOf(asc), []string{"missing"}))
+}
+
+func newScatterDB(t *testing.T, shardRows ...[][]driver.Value) (*DB, []*fakeNode) {
+	clusters := make([]*Cluster, 0, len(shardRows))
+	nodes := make([]*fakeNode, 0, len(shardRows))
+	for _, rows := range shardRows {
+		rows := rows
+		db, node := newFakeDB("shard", "mysql")
+		node.Rows = func(query string) ([]string, [][]driver.Value) {
+			if strings.Contains(query, "COUNT(1)") {
+				return []string{"count"}, [][]driver.Value{{int64(len(rows))}}
+			}
+			return []string{"id", "score", "name"}, rows
+		}
+		clusters = append(clusters, NewRRCluster([]*sqlx.DB{db}, []*sqlx.DB{db}))
+		nodes = append(nodes, node)
+	}
+	return &DB{resolver: NewShardedCluster(HashModStrategy{}, clusters...)}, nodes
+}
+
+func TestDB_SelectAll(t *testing.T) {
+	db, nodes := newScatterDB(t,
+		[][]driver.Value{{int64(1), int64(30), "a"}, {int64(3), nil, "c"}},
+		[][]driver.Value{{int64(2), int64(20), nil}, {int64(4), int64(10), "d"}},
+	)
+	ctx := context.Background()
+
+	var rows []scatterRow
+	query := builder.Query().From("users").OrderBy("score").LimitOffset(2, 1)
+	require.NoError(t, db.SelectAll(ctx, &rows, query))
+	require.Len(t, rows, 2)
+	assert.Equal(t, int64(2), rows[0].ID)
+	assert.Equal(t, int64(1), rows[1].ID)
+	for _, node := range nodes {
+		assert.Equal(t, []string{"SELECT * FROM users ORDER BY score LIMIT 3"}, node.Log())
+	}
+
+	rows = nil
+	query = builder.Query().From("users").OrderBy("id DESC").LimitOffset(10, 10)
+	require.NoError(t, db.SelectAll(ctx, &rows, query))
+	assert.Empty(t, rows)
+
+	grouped := []*builder.QueryBuilder{
+		builder.Query().Select("name", "COUNT(1)").From("users").GroupBy("name"),
+		builder.Query().Select("DISTINCT name").From("users"),
+		builder.Query().Select("max(score)").From("users"),
+	}
+	for _, query := range grouped {
+		assert.Equal(t, ErrScatterGrouped, db.SelectAll(ctx, &rows, query))
+	}
+}
+
+func TestDB_CountAll(t *testing.T) {
+	db, nodes := newScatterDB(t,
+		[][]driver.Value{{int64(1), int64(30), "a"}, {int64(3), nil, "c"}},
+		[][]driver.Value{{int64(2), int64(20), nil}},
+	)
+	ctx := context.Background()
+
+	count, err := db.CountAll(ctx, builder.Query().From("users").And("score > ?", 1).OrderBy("score DESC").LimitOffset(10, 0))
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+	for _, node := range nodes {
+		assert.Equal(t, []string{"SELECT COUNT(1) FROM users WHERE score > ?"}, node.Log())
+	}
+
+	_, err = db.CountAll(ctx, builder.Query().From("users").GroupBy("name"))
+	assert.Equal(t, ErrScatterGrouped, err)
+	_, err = db.CountAll(ctx, builder.Query().Select("DISTINCT name").From("users"))
+	assert.Equal(t, ErrScatterGrouped, err)
+}
@@ -0,0 +1,47 @@
+package sqlxx
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+type SessionKey struct{}
+
+// WithSession attaches a read-your-writes session to ctx, once a write is done
+// through ctx, the reads of ctx are routed to master for the following window.
+func WithSession(ctx context.Context, window time.Duration) context.Context {
+	return context.WithValue(ctx, SessionKey{}, &Session{window: window})
+}
+
+func GetSession(ctx context.Context) *Session {
+	session, ok := ctx.Value(SessionKey{}).(*Session)
+	if !ok {
+		return nil
+	}
+	return session
+}
+
+type Session struct {
+	window    time.Duration
+	lastWrite int64
+}
+
+func (s *Session) MarkWrite() {
+	if s == nil {
+		return
+	}
+	atomic.StoreInt64(&s.lastWrite, time.Now().UnixNano())
+}
+
+// StickToMaster reports whether the session wrote within its window.
+func (s *Session) StickToMaster() bool {
+	if s == nil {
+		return false
+	}
+	lastWrite := atomic.LoadInt64(&s.lastWrite)
+	if lastWrite == 0 {
+		return false
+	}
+	return time.Since(time.Unix(0, lastWrite)) < s.window
+}
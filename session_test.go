@@ -0,0 +1,65 @@
+package sqlxx
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSession_StickToMaster(t *testing.T) {
+	master, _ := newFakeDB("master", "mysql")
+	slave, _ := newFakeDB("slave", "mysql")
+	adapter := NewWithCluster([]*sqlx.DB{master}, []*sqlx.DB{slave})
+	ctx := WithSession(context.Background(), 50*time.Millisecond)
+	read := func() string {
+		var name string
+		require.NoError(t, adapter.GetDB(ctx).GetContext(ctx, &name, "SELECT name FROM users"))
+		return name
+	}
+
+	assert.Equal(t, "slave", read())
+	_, err := adapter.GetDB(ctx).ExecContext(ctx, "UPDATE users SET name = ?", "a")
+	require.NoError(t, err)
+	assert.True(t, GetSession(ctx).StickToMaster())
+	assert.Equal(t, "master", read(), "reads its own write")
+
+	// the other sessions are not affected
+	var name string
+	require.NoError(t, adapter.GetDB(context.Background()).GetContext(context.Background(), &name, "SELECT name FROM users"))
+	assert.Equal(t, "slave", name)
+
+	time.Sleep(60 * time.Millisecond)
+	assert.False(t, GetSession(ctx).StickToMaster())
+	assert.Equal(t, "slave", read(), "the window is expired")
+}
+
+func TestSession_Tx(t *testing.T) {
+	master, _ := newFakeDB("master", "mysql")
+	slave, _ := newFakeDB("slave", "mysql")
+	adapter := NewWithCluster([]*sqlx.DB{master}, []*sqlx.DB{slave})
+	ctx := WithSession(context.Background(), time.Minute)
+	fn := func(txCtx context.Context) error {
+		return nil
+	}
+
+	require.NoError(t, adapter.ExecuteTx(ctx, fn, WithTxOptions(&sql.TxOptions{ReadOnly: true})))
+	assert.False(t, GetSession(ctx).StickToMaster(), "a read only tx is not a write")
+	require.NoError(t, adapter.ViewTx(ctx, fn))
+	assert.False(t, GetSession(ctx).StickToMaster())
+
+	// the write is marked on commit, not by the statements of the tx
+	err := adapter.ExecuteTx(ctx, func(txCtx context.Context) error {
+		if _, err := adapter.GetDB(txCtx).ExecContext(txCtx, "UPDATE users SET name = ?", "a"); err != nil {
+			return err
+		}
+		assert.False(t, GetSession(ctx).StickToMaster())
+		return nil
+	})
+	require.NoError(t, err)
+	assert.True(t, GetSession(ctx).StickToMaster())
+}
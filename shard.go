@@ -0,0 +1,196 @@
+package sqlxx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+type ShardKey struct{}
+
+func WithShardKey(ctx context.Context, key interface{}) context.Context {
+	return context.WithValue(ctx, ShardKey{}, key)
+}
+
+func GetShardKey(ctx context.Context) (interface{}, bool) {
+	key := ctx.Value(ShardKey{})
+	return key, key != nil
+}
+
+var ErrNoShardKey = errors.New("shard key is not set")
+
+// ShardStrategy maps a shard key to the index of one of n shards.
+type ShardStrategy interface {
+	Shard(key interface{}, n int) (int, error)
+}
+
+// HashModStrategy uses |key| % n for integer keys and fnv32a(key) % n for the
+// others. Strings and []byte holding a base 10 integer are treated as that
+// integer, so 5 and "5" go to the same shard.
+type HashModStrategy struct{}
+
+func (HashModStrategy) Shard(key interface{}, n int) (int, error) {
+	if n <= 0 {
+		return 0, ErrNoDBPool
+	}
+	if u, ok := toMagnitude(key); ok {
+		return int(u % uint64(n)), nil
+	}
+
+	h := fnv.New32a()
+	switch k := key.(type) {
+	case string:
+		h.Write([]byte(k))
+	case []byte:
+		h.Write(k)
+	default:
+		h.Write([]byte(fmt.Sprint(k)))
+	}
+	return int(h.Sum32() % uint32(n)), nil
+}
+
+// RangeStrategy routes integer keys by upper bounds, shard i holds the keys in
+// [Bounds[i-1], Bounds[i]). Strings holding a base 10 integer are accepted too.
+type RangeStrategy struct {
+	Bounds []int64
+}
+
+func (s RangeStrategy) Shard(key interface{}, n int) (int, error) {
+	i, ok := toInt64(key)
+	if !ok {
+		if _, isInt := toMagnitude(key); isInt {
+			return 0, fmt.Errorf("range shard key(%v) is out of range", key)
+		}
+		return 0, fmt.Errorf("range shard key(%v) is not integer", key)
+	}
+	idx := sort.Search(len(s.Bounds), func(j int) bool {
+		return i < s.Bounds[j]
+	})
+	if idx >= len(s.Bounds) || idx >= n {
+		return 0, fmt.Errorf("range shard key(%v) is out of range", key)
+	}
+	return idx, nil
+}
+
+// LookupStrategy routes keys by a lookup table, keys are formatted with fmt.Sprint.
+type LookupStrategy map[string]int
+
+func (s LookupStrategy) Shard(key interface{}, n int) (int, error) {
+	idx, ok := s[fmt.Sprint(key)]
+	if !ok {
+		return 0, fmt.Errorf("shard key(%v) is not in lookup table", key)
+	}
+	if idx < 0 || idx >= n {
+		return 0, fmt.Errorf("lookup shard(%d) of key(%v) is out of range", idx, key)
+	}
+	return idx, nil
+}
+
+func NewShardedCluster(strategy ShardStrategy, shards ...*Cluster) *ShardedCluster {
+	return &ShardedCluster{
+		strategy: strategy,
+		shards:   shards,
+	}
+}
+
+// ShardedCluster holds one Cluster per shard and resolves the shard from the key
+// attached by WithShardKey.
+type ShardedCluster struct {
+	strategy ShardStrategy
+	shards   []*Cluster
+}
+
+func (sc *ShardedCluster) Shards() []*Cluster {
+	shards := make([]*Cluster, len(sc.shards))
+	copy(shards, sc.shards)
+	return shards
+}
+
+func (sc *ShardedCluster) GetCluster(ctx context.Context) (*Cluster, error) {
+	key, ok := GetShardKey(ctx)
+	if !ok {
+		return nil, ErrNoShardKey
+	}
+	idx, err := sc.strategy.Shard(key, len(sc.shards))
+	if err != nil {
+		return nil, err
+	}
+	if idx < 0 || idx >= len(sc.shards) {
+		return nil, fmt.Errorf("shard(%d) is out of range", idx)
+	}
+	return sc.shards[idx], nil
+}
+
+func (sc *ShardedCluster) Close() error {
+	for _, shard := range sc.shards {
+		shard.Close()
+	}
+	return nil
+}
+
+// toInt64 converts integer keys and strings holding a base 10 integer, unsigned
+// keys above math.MaxInt64 are rejected.
+func toInt64(key interface{}) (int64, bool) {
+	val := reflect.ValueOf(key)
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return val.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if val.Uint() > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(val.Uint()), true
+	}
+	if s, ok := keyString(key); ok {
+		i, err := strconv.ParseInt(s, 10, 64)
+		return i, err == nil
+	}
+	return 0, false
+}
+
+// toMagnitude returns the absolute value of integer keys and strings holding a
+// base 10 integer, it covers the whole int64 and uint64 range without wrapping.
+func toMagnitude(key interface{}) (uint64, bool) {
+	val := reflect.ValueOf(key)
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return absInt64(val.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return val.Uint(), true
+	}
+	s, ok := keyString(key)
+	if !ok {
+		return 0, false
+	}
+	if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return u, true
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return absInt64(i), true
+	}
+	return 0, false
+}
+
+func absInt64(i int64) uint64 {
+	if i < 0 {
+		// -(i+1)+1 keeps math.MinInt64 from overflowing
+		return uint64(-(i + 1)) + 1
+	}
+	return uint64(i)
+}
+
+func keyString(key interface{}) (string, bool) {
+	switch k := key.(type) {
+	case string:
+		return k, true
+	case []byte:
+		return string(k), true
+	default:
+		return "", false
+	}
+}
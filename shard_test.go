@@ -0,0 +1,107 @@
+package sqlxx
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashModStrategy(t *testing.T) {
+	testCases := []struct {
+		key  interface{}
+		want int
+	}{
+		{5, 1},
+		{int64(-5), 1},
+		{uint8(6), 2},
+		{"5", 1},
+		{[]byte("5"), 1},
+		{"-5", 1},
+		{uint64(math.MaxUint64), int(uint64(math.MaxUint64) % 4)},
+		{uint64(math.MaxInt64) + 1, 0},
+		{int64(math.MinInt64), 0},
+	}
+	for _, tc := range testCases {
+		idx, err := HashModStrategy{}.Shard(tc.key, 4)
+		require.NoError(t, err, "%v", tc.key)
+		assert.Equal(t, tc.want, idx, "%v", tc.key)
+	}
+
+	a, err := HashModStrategy{}.Shard("user-a", 4)
+	require.NoError(t, err)
+	b, err := HashModStrategy{}.Shard([]byte("user-a"), 4)
+	require.NoError(t, err)
+	assert.Equal(t, a, b)
+	assert.True(t, a >= 0 && a < 4)
+
+	_, err = HashModStrategy{}.Shard(1, 0)
+	assert.Equal(t, ErrNoDBPool, err)
+}
+
+func TestRangeStrategy(t *testing.T) {
+	s := RangeStrategy{Bounds: []int64{100, 200, 300}}
+	testCases := []struct {
+		key  interface{}
+		want int
+		err  bool
+	}{
+		{-1, 0, false},
+		{99, 0, false},
+		{100, 1, false},
+		{uint16(250), 2, false},
+		{"150", 1, false},
+		{300, 0, true},
+		{uint64(math.MaxUint64), 0, true},
+		{"abc", 0, true},
+		{1.5, 0, true},
+	}
+	for _, tc := range testCases {
+		idx, err := s.Shard(tc.key, 3)
+		if tc.err {
+			assert.Error(t, err, "%v", tc.key)
+			continue
+		}
+		require.NoError(t, err, "%v", tc.key)
+		assert.Equal(t, tc.want, idx, "%v", tc.key)
+	}
+
+	// bounds beyond the shard count are out of range
+	_, err := s.Shard(250, 2)
+	assert.Error(t, err)
+}
+
+func TestLookupStrategy(t *testing.T) {
+	s := LookupStrategy{"tw": 0, "jp": 1, "7": 1, "bad": 5}
+
+	idx, err := s.Shard("jp", 2)
+	require.NoError(t, err)
+	assert.Equal(t, 1, idx)
+
+	idx, err = s.Shard(7, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 1, idx)
+
+	_, err = s.Shard("us", 2)
+	assert.Error(t, err)
+	_, err = s.Shard("bad", 2)
+	assert.Error(t, err)
+}
+
+func TestShardedCluster_GetCluster(t *testing.T) {
+	a, b := NewRRCluster(nil, nil), NewRRCluster(nil, nil)
+	sc := NewShardedCluster(HashModStrategy{}, a, b)
+
+	_, err := sc.GetCluster(context.Background())
+	assert.Equal(t, ErrNoShardKey, err)
+
+	c, err := sc.GetCluster(WithShardKey(context.Background(), 3))
+	require.NoError(t, err)
+	assert.Same(t, b, c)
+
+	c, err = sc.GetCluster(WithShardKey(context.Background(), "4"))
+	require.NoError(t, err)
+	assert.Same(t, a, c)
+}
@@ -0,0 +1,127 @@
+package sqlxx
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	NodeHealthy  = "healthy"
+	NodeEjected  = "ejected"
+	NodeOpen     = "open"
+	NodeHalfOpen = "half-open"
+)
+
+type NodeStats struct {
+	Role       string        `json:"role"`
+	Index      int           `json:"index"`
+	DBStats    sql.DBStats   `json:"db_stats"`
+	Queries    uint64        `json:"queries"`
+	Errors     uint64        `json:"errors"`
+	AvgLatency time.Duration `json:"avg_latency"`
+	State      string        `json:"state"`
+}
+
+type ClusterStats struct {
+	Masters []NodeStats `json:"masters"`
+	Slaves  []NodeStats `json:"slaves"`
+}
+
+type nodeCounter struct {
+	queries uint64
+	errors  uint64
+	cost    int64
+}
+
+type clusterCounters struct {
+	nodes sync.Map
+}
+
+func (cc *clusterCounters) observe(db *sqlx.DB, err error, cost time.Duration) {
+	val, ok := cc.nodes.Load(db)
+	if !ok {
+		val, _ = cc.nodes.LoadOrStore(db, &nodeCounter{})
+	}
+	counter := val.(*nodeCounter)
+	atomic.AddUint64(&counter.queries, 1)
+	atomic.AddInt64(&counter.cost, int64(cost))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		atomic.AddUint64(&counter.errors, 1)
+	}
+}
+
+func (cc *clusterCounters) get(db *sqlx.DB) (queries, errs uint64, avg time.Duration) {
+	val, ok := cc.nodes.Load(db)
+	if !ok {
+		return 0, 0, 0
+	}
+	counter := val.(*nodeCounter)
+	queries = atomic.LoadUint64(&counter.queries)
+	errs = atomic.LoadUint64(&counter.errors)
+	if queries > 0 {
+		avg = time.Duration(atomic.LoadInt64(&counter.cost) / int64(queries))
+	}
+	return queries, errs, avg
+}
+
+// Stats returns the connection pool stats, routed queries, errors, average
+// latency and health state of every master and slave.
+func (c *Cluster) Stats() ClusterStats {
+	return ClusterStats{
+		Masters: c.nodeStats("master", c.Masters()),
+		Slaves:  c.nodeStats("slave", c.Slaves()),
+	}
+}
+
+func (c *Cluster) nodeStats(role string, dbs []*sqlx.DB) []NodeStats {
+	res := make([]NodeStats, 0, len(dbs))
+	for i, db := range dbs {
+		queries, errs, avg := c.counters.get(db)
+		res = append(res, NodeStats{
+			Role:       role,
+			Index:      i,
+			DBStats:    db.Stats(),
+			Queries:    queries,
+			Errors:     errs,
+			AvgLatency: avg,
+			State:      c.nodeState(db),
+		})
+	}
+	return res
+}
+
+func (c *Cluster) nodeState(db *sqlx.DB) string {
+	c.lock.RLock()
+	health, breaker := c.health, c.breaker
+	c.lock.RUnlock()
+
+	if health != nil && !health.IsHealthy(db) {
+		return NodeEjected
+	}
+	if breaker != nil {
+		switch breaker.State(db) {
+		case BreakerOpen:
+			return NodeOpen
+		case BreakerHalfOpen:
+			return NodeHalfOpen
+		}
+	}
+	return NodeHealthy
+}
+
+// StatsHandler serves the stats of c as json.
+func StatsHandler(c *Cluster) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(c.Stats()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
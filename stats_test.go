@@ -0,0 +1,87 @@
+package sqlxx
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newStatsCluster(t *testing.T) *Sqlxx {
+	master, _ := newFakeDB("master", "mysql")
+	slave, slaveNode := newFakeDB("slave", "mysql")
+	slaveNode.Delay = 5 * time.Millisecond
+	slaveNode.Rows = func(query string) ([]string, [][]driver.Value) {
+		if strings.Contains(query, "missing") {
+			return []string{"name"}, nil
+		}
+		return []string{"name"}, [][]driver.Value{{"slave"}}
+	}
+	adapter := NewWithCluster([]*sqlx.DB{master}, []*sqlx.DB{slave})
+	ctx := context.Background()
+
+	var name string
+	require.NoError(t, adapter.GetDB(ctx).GetContext(ctx, &name, "SELECT name FROM users"))
+	require.NoError(t, adapter.GetDB(ctx).GetContext(ctx, &name, "SELECT name FROM users"))
+	// ErrNoRows is a result, not an error of the node
+	assert.ErrorIs(t, adapter.GetDB(ctx).GetContext(ctx, &name, "SELECT name FROM missing"), sql.ErrNoRows)
+	slaveNode.SetErr(errors.New("syntax error"))
+	assert.Error(t, adapter.GetDB(ctx).GetContext(ctx, &name, "SELECT name FROM users"))
+	slaveNode.SetErr(nil)
+
+	_, err := adapter.GetDB(ctx).ExecContext(ctx, "UPDATE users SET name = ?", "a")
+	require.NoError(t, err)
+	return adapter
+}
+
+func TestCluster_Stats(t *testing.T) {
+	adapter := newStatsCluster(t)
+	stats := adapter.db.Cluster.Stats()
+
+	require.Len(t, stats.Masters, 1)
+	assert.Equal(t, "master", stats.Masters[0].Role)
+	assert.Equal(t, uint64(1), stats.Masters[0].Queries)
+	assert.Equal(t, uint64(0), stats.Masters[0].Errors)
+
+	require.Len(t, stats.Slaves, 1)
+	slave := stats.Slaves[0]
+	assert.Equal(t, "slave", slave.Role)
+	assert.Equal(t, 0, slave.Index)
+	assert.Equal(t, uint64(4), slave.Queries)
+	assert.Equal(t, uint64(1), slave.Errors)
+	assert.GreaterOrEqual(t, int64(slave.AvgLatency), int64(5*time.Millisecond))
+	assert.Equal(t, NodeHealthy, slave.State)
+	assert.Equal(t, 1, slave.DBStats.OpenConnections)
+}
+
+func TestStatsHandler(t *testing.T) {
+	adapter := newStatsCluster(t)
+
+	rec := httptest.NewRecorder()
+	StatsHandler(adapter.db.Cluster).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/sqlxx", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var body map[string][]map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	require.Len(t, body["masters"], 1)
+	require.Len(t, body["slaves"], 1)
+	slave := body["slaves"][0]
+	assert.Equal(t, "slave", slave["role"])
+	assert.Equal(t, float64(0), slave["index"])
+	assert.Equal(t, float64(4), slave["queries"])
+	assert.Equal(t, float64(1), slave["errors"])
+	assert.GreaterOrEqual(t, slave["avg_latency"], float64(5*time.Millisecond))
+	assert.Equal(t, NodeHealthy, slave["state"])
+	assert.Contains(t, slave, "db_stats")
+}
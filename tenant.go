@@ -0,0 +1,217 @@
+package sqlxx
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+type TenantKey struct{}
+
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, TenantKey{}, tenantID)
+}
+
+func GetTenant(ctx context.Context) (string, bool) {
+	tenantID, ok := ctx.Value(TenantKey{}).(string)
+	return tenantID, ok && tenantID != ""
+}
+
+var ErrNoTenant = errors.New("tenant is not set")
+
+// TenantResolver maps a tenant to its cluster, it is called lazily on the first
+// request of the tenant and again after the tenant pool is evicted.
+type TenantResolver interface {
+	Resolve(ctx context.Context, tenantID string) (*Cluster, error)
+}
+
+type TenantResolverFunc func(ctx context.Context, tenantID string) (*Cluster, error)
+
+func (f TenantResolverFunc) Resolve(ctx context.Context, tenantID string) (*Cluster, error) {
+	return f(ctx, tenantID)
+}
+
+type TenantRegistryConfig struct {
+	// IdleTimeout is the idle duration before a tenant pool is evicted, default is 30m
+	IdleTimeout time.Duration
+	// CheckInterval is the interval of idle checks, default is 1m
+	CheckInterval time.Duration
+	// OnEvict is called with the evicted cluster, default closes the cluster and its dbs
+	OnEvict func(tenantID string, cluster *Cluster)
+}
+
+func (cfg *TenantRegistryConfig) setDefault() {
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = 30 * time.Minute
+	}
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = time.Minute
+	}
+	if cfg.OnEvict == nil {
+		cfg.OnEvict = closeCluster
+	}
+}
+
+func NewTenantRegistry(resolver TenantResolver, cfg TenantRegistryConfig) *TenantRegistry {
+	cfg.setDefault()
+	r := &TenantRegistry{
+		resolver: resolver,
+		cfg:      cfg,
+		tenants:  make(map[string]*tenantEntry),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+type tenantEntry struct {
+	ready    chan struct{}
+	cluster  *Cluster
+	err      error
+	lastUsed time.Time
+}
+
+// TenantRegistry resolves the cluster of the tenant attached by WithTenant and
+// evicts the tenant pools which stay idle longer than IdleTimeout.
+type TenantRegistry struct {
+	resolver TenantResolver
+	cfg      TenantRegistryConfig
+	lock     sync.Mutex
+	tenants  map[string]*tenantEntry
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+func (r *TenantRegistry) GetCluster(ctx context.Context) (*Cluster, error) {
+	tenantID, ok := GetTenant(ctx)
+	if !ok {
+		return nil, ErrNoTenant
+	}
+
+	r.lock.Lock()
+	entry, ok := r.tenants[tenantID]
+	if !ok {
+		entry = &tenantEntry{ready: make(chan struct{})}
+		r.tenants[tenantID] = entry
+	}
+	entry.lastUsed = time.Now()
+	r.lock.Unlock()
+
+	if !ok {
+		entry.cluster, entry.err = r.resolver.Resolve(ctx, tenantID)
+		if entry.err == nil && entry.cluster == nil {
+			entry.err = ErrNoDBPool
+		}
+		if entry.err != nil {
+			r.lock.Lock()
+			delete(r.tenants, tenantID)
+			r.lock.Unlock()
+		}
+		close(entry.ready)
+	}
+
+	select {
+	case <-entry.ready:
+		return entry.cluster, entry.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Evict removes the pool of tenantID, it is resolved again on the next request.
+func (r *TenantRegistry) Evict(tenantID string) {
+	r.lock.Lock()
+	entry, ok := r.tenants[tenantID]
+	if ok {
+		delete(r.tenants, tenantID)
+	}
+	r.lock.Unlock()
+
+	if ok {
+		r.evict(tenantID, entry)
+	}
+}
+
+// Close stops the idle checker and evicts every tenant pool.
+func (r *TenantRegistry) Close() error {
+	r.once.Do(func() {
+		close(r.stop)
+	})
+	<-r.done
+
+	r.lock.Lock()
+	tenants := r.tenants
+	r.tenants = make(map[string]*tenantEntry)
+	r.lock.Unlock()
+
+	for tenantID, entry := range tenants {
+		r.evict(tenantID, entry)
+	}
+	return nil
+}
+
+func (r *TenantRegistry) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(r.cfg.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.evictIdle()
+		}
+	}
+}
+
+func (r *TenantRegistry) evictIdle() {
+	idle := make(map[string]*tenantEntry)
+	r.lock.Lock()
+	for tenantID, entry := range r.tenants {
+		if time.Since(entry.lastUsed) < r.cfg.IdleTimeout {
+			continue
+		}
+		select {
+		case <-entry.ready:
+		default:
+			continue
+		}
+		if entry.cluster != nil && clusterInUse(entry.cluster) {
+			continue
+		}
+		idle[tenantID] = entry
+		delete(r.tenants, tenantID)
+	}
+	r.lock.Unlock()
+
+	for tenantID, entry := range idle {
+		r.evict(tenantID, entry)
+	}
+}
+
+func (r *TenantRegistry) evict(tenantID string, entry *tenantEntry) {
+	<-entry.ready
+	if entry.cluster != nil {
+		r.cfg.OnEvict(tenantID, entry.cluster)
+	}
+}
+
+func clusterInUse(cluster *Cluster) bool {
+	for _, db := range cluster.Nodes() {
+		if db.Stats().InUse > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func closeCluster(tenantID string, cluster *Cluster) {
+	cluster.Close()
+	for _, db := range cluster.Nodes() {
+		db.Close()
+	}
+}
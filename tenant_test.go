@@ -0,0 +1,144 @@
+package sqlxx
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTenantCluster(tenantID string) *Cluster {
+	db, _ := newFakeDB(tenantID, "mysql")
+	return NewRRCluster([]*sqlx.DB{db}, []*sqlx.DB{db})
+}
+
+func TestTenantRegistry_Resolve(t *testing.T) {
+	var resolved int32
+	registry := NewTenantRegistry(TenantResolverFunc(func(ctx context.Context, tenantID string) (*Cluster, error) {
+		atomic.AddInt32(&resolved, 1)
+		time.Sleep(10 * time.Millisecond)
+		return newTenantCluster(tenantID), nil
+	}), TenantRegistryConfig{})
+	adapter := NewWithTenants(registry)
+	defer adapter.Close()
+	assert.Equal(t, int32(0), atomic.LoadInt32(&resolved), "resolved on the first request")
+
+	_, err := adapter.GetDB(context.Background()).ExecContext(context.Background(), "UPDATE users SET name = ?", "a")
+	assert.ErrorIs(t, err, ErrNoTenant)
+
+	// the concurrent first requests share one resolve
+	ctx := WithTenant(context.Background(), "a")
+	clusters := make([]*Cluster, 8)
+	var wg sync.WaitGroup
+	for i := range clusters {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			clusters[i], _ = registry.GetCluster(ctx)
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&resolved))
+	for _, cluster := range clusters {
+		require.NotNil(t, cluster)
+		assert.Same(t, clusters[0], cluster)
+	}
+
+	var name string
+	require.NoError(t, adapter.GetDB(ctx).GetContext(ctx, &name, "SELECT name FROM users"))
+	assert.Equal(t, "a", name)
+	bCtx := WithTenant(context.Background(), "b")
+	require.NoError(t, adapter.GetDB(bCtx).GetContext(bCtx, &name, "SELECT name FROM users"))
+	assert.Equal(t, "b", name)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&resolved))
+}
+
+func TestTenantRegistry_ResolveError(t *testing.T) {
+	var resolved int32
+	registry := NewTenantRegistry(TenantResolverFunc(func(ctx context.Context, tenantID string) (*Cluster, error) {
+		if atomic.AddInt32(&resolved, 1) == 1 {
+			return nil, errors.New("tenant store is down")
+		}
+		return newTenantCluster(tenantID), nil
+	}), TenantRegistryConfig{})
+	defer registry.Close()
+	ctx := WithTenant(context.Background(), "a")
+
+	_, err := registry.GetCluster(ctx)
+	assert.EqualError(t, err, "tenant store is down")
+	// the failed resolve is not cached
+	cluster, err := registry.GetCluster(ctx)
+	require.NoError(t, err)
+	assert.NotNil(t, cluster)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&resolved))
+}
+
+func TestTenantRegistry_EvictIdle(t *testing.T) {
+	evicted := make(chan string, 2)
+	registry := NewTenantRegistry(TenantResolverFunc(func(ctx context.Context, tenantID string) (*Cluster, error) {
+		return newTenantCluster(tenantID), nil
+	}), TenantRegistryConfig{
+		IdleTimeout: time.Millisecond,
+		// the idle checks are run by the test
+		CheckInterval: time.Hour,
+		OnEvict: func(tenantID string, cluster *Cluster) {
+			evicted <- tenantID
+		},
+	})
+	defer registry.Close()
+	adapter := NewWithTenants(registry)
+
+	aCtx, bCtx := WithTenant(context.Background(), "a"), WithTenant(context.Background(), "b")
+	txDB, err := adapter.GetDB(aCtx).Begin(aCtx, nil)
+	require.NoError(t, err)
+	_, err = registry.GetCluster(bCtx)
+	require.NoError(t, err)
+
+	// the cluster of a is in use by the tx
+	time.Sleep(5 * time.Millisecond)
+	registry.evictIdle()
+	assert.Equal(t, "b", <-evicted)
+	assert.Empty(t, evicted)
+
+	require.NoError(t, txDB.Commit(aCtx))
+	registry.evictIdle()
+	assert.Equal(t, "a", <-evicted)
+	assert.Empty(t, registry.tenants)
+}
+
+func TestTenantRegistry_Close(t *testing.T) {
+	nodes := make(map[string]*fakeNode)
+	var lock sync.Mutex
+	registry := NewTenantRegistry(TenantResolverFunc(func(ctx context.Context, tenantID string) (*Cluster, error) {
+		db, node := newFakeDB(tenantID, "mysql")
+		lock.Lock()
+		nodes[tenantID] = node
+		lock.Unlock()
+		return NewRRCluster([]*sqlx.DB{db}, []*sqlx.DB{db}), nil
+	}), TenantRegistryConfig{})
+	adapter := NewWithTenants(registry)
+
+	for _, tenantID := range []string{"a", "b"} {
+		ctx := WithTenant(context.Background(), tenantID)
+		var name string
+		require.NoError(t, adapter.GetDB(ctx).GetContext(ctx, &name, "SELECT name FROM users"))
+	}
+
+	// the pools are closed by the default OnEvict
+	require.NoError(t, adapter.Close())
+	assert.Empty(t, registry.tenants)
+	for tenantID, node := range nodes {
+		assert.Equal(t, int64(1), node.Closed(), tenantID)
+	}
+	select {
+	case <-registry.done:
+	default:
+		require.FailNow(t, "the idle checker is still running")
+	}
+}
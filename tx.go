@@ -0,0 +1,219 @@
+package sqlxx
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"time"
+
+	"github.com/vx416/sqlxx/logger"
+)
+
+var (
+	ErrNoTx     = errors.New("no transaction in context")
+	ErrTxExists = errors.New("transaction exists in context")
+)
+
+// Propagation decides how ExecuteTx runs when ctx has a transaction or not.
+type Propagation int
+
+const (
+	// PropagationNested runs in a savepoint of the current tx, or in a new tx if there is none
+	PropagationNested Propagation = iota
+	// PropagationRequired joins the current tx, or runs in a new tx if there is none
+	PropagationRequired
+	// PropagationRequiresNew suspends the current tx and always runs in a new tx
+	PropagationRequiresNew
+	// PropagationMandatory joins the current tx, ErrNoTx is returned if there is none
+	PropagationMandatory
+	// PropagationNever runs without tx, ErrTxExists is returned if there is one
+	PropagationNever
+	// PropagationSupports joins the current tx, or runs without tx if there is none
+	PropagationSupports
+)
+
+type TxTimeoutKey struct{}
+
+// WithTxTimeout limits how long the tx of ExecuteTx stays open, the tx is rolled
+// back when the timeout expires. A tx of DB.Begin is bounded by the deadline of
+// its ctx instead.
+func WithTxTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, TxTimeoutKey{}, timeout)
+}
+
+func GetTxTimeout(ctx context.Context) (time.Duration, bool) {
+	timeout, ok := ctx.Value(TxTimeoutKey{}).(time.Duration)
+	return timeout, ok && timeout > 0
+}
+
+// watchLongTx warns through the logger of ctx with the stack of the caller when
+// the tx is still open after logger.LongTxThreshold.
+func watchLongTx(ctx context.Context) (stop func()) {
+	threshold := logger.LongTxThreshold
+	if threshold <= 0 || logger.GetLogger(ctx) == nil {
+		return func() {}
+	}
+	start := time.Now()
+	// the stack is only formatted if the warning is printed
+	pcs := make([]uintptr, 32)
+	pcs = pcs[:runtime.Callers(2, pcs)]
+	timer := time.AfterFunc(threshold, func() {
+		logger.PrintLongTx(ctx, time.Since(start), formatStack(pcs))
+	})
+	return func() {
+		timer.Stop()
+	}
+}
+
+func formatStack(pcs []uintptr) []byte {
+	var buf bytes.Buffer
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&buf, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			return buf.Bytes()
+		}
+	}
+}
+
+type TxOption func(cfg *txConfig)
+
+type txConfig struct {
+	txOpt       *sql.TxOptions
+	maxAttempts int
+	backoff     time.Duration
+	propagation Propagation
+	panicError  bool
+}
+
+func newTxConfig(opts ...TxOption) *txConfig {
+	cfg := &txConfig{maxAttempts: 1}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+func WithTxOptions(txOpt *sql.TxOptions) TxOption {
+	return func(cfg *txConfig) {
+		cfg.txOpt = txOpt
+	}
+}
+
+// WithRetry re-runs the whole callback in a new transaction when it fails with a
+// retryable error, the wait between attempts grows exponentially from backoff
+// with jitter.
+func WithRetry(maxAttempts int, backoff time.Duration) TxOption {
+	return func(cfg *txConfig) {
+		cfg.maxAttempts = maxAttempts
+		cfg.backoff = backoff
+	}
+}
+
+// WithPropagation sets the propagation of ExecuteTx, default is PropagationNested.
+func WithPropagation(propagation Propagation) TxOption {
+	return func(cfg *txConfig) {
+		cfg.propagation = propagation
+	}
+}
+
+// WithPanicError makes ExecuteTx return a *TxPanicError when the callback panics,
+// by default the panic is raised again after the tx is rolled back.
+func WithPanicError() TxOption {
+	return func(cfg *txConfig) {
+		cfg.panicError = true
+	}
+}
+
+// TxError is returned by ExecuteTx when the tx fails, Callback is the error of
+// the callback, Commit and Rollback are the errors of ending the tx.
+type TxError struct {
+	Callback error
+	Commit   error
+	Rollback error
+}
+
+func (e *TxError) Error() string {
+	switch {
+	case e.Callback != nil && e.Rollback != nil:
+		return fmt.Sprintf("tx callback: %v, rollback: %v", e.Callback, e.Rollback)
+	case e.Callback != nil:
+		return fmt.Sprintf("tx callback: %v", e.Callback)
+	case e.Commit != nil:
+		return fmt.Sprintf("tx commit: %v", e.Commit)
+	default:
+		return fmt.Sprintf("tx rollback: %v", e.Rollback)
+	}
+}
+
+// Unwrap returns the error causing the tx to fail.
+func (e *TxError) Unwrap() error {
+	switch {
+	case e.Callback != nil:
+		return e.Callback
+	case e.Commit != nil:
+		return e.Commit
+	default:
+		return e.Rollback
+	}
+}
+
+// Cause is for github.com/pkg/errors.Cause.
+func (e *TxError) Cause() error {
+	return e.Unwrap()
+}
+
+// TxPanicError is returned instead of raising the panic of the callback when
+// WithPanicError is set.
+type TxPanicError struct {
+	Value interface{}
+	// Stack is the stack of the panic
+	Stack    []byte
+	Rollback error
+}
+
+func (e *TxPanicError) Error() string {
+	if e.Rollback != nil {
+		return fmt.Sprintf("tx callback panic: %v, rollback: %v", e.Value, e.Rollback)
+	}
+	return fmt.Sprintf("tx callback panic: %v", e.Value)
+}
+
+func (e *TxPanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+const maxBackoffShift = 10
+
+func retryBackoff(backoff time.Duration, attempt int) time.Duration {
+	if backoff <= 0 {
+		return 0
+	}
+	shift := attempt - 1
+	if shift > maxBackoffShift {
+		shift = maxBackoffShift
+	}
+	d := backoff << uint(shift)
+	// equal jitter, wait between d/2 and d
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,270 @@
+package sqlxx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vx416/sqlxx/logger"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	assert.Equal(t, time.Duration(0), retryBackoff(0, 1))
+	assert.Equal(t, time.Duration(0), retryBackoff(-time.Second, 3))
+
+	backoff := 10 * time.Millisecond
+	for attempt := 1; attempt <= 15; attempt++ {
+		shift := attempt - 1
+		if shift > maxBackoffShift {
+			shift = maxBackoffShift
+		}
+		max := backoff << uint(shift)
+		for i := 0; i < 20; i++ {
+			d := retryBackoff(backoff, attempt)
+			assert.True(t, d >= max/2 && d <= max, "attempt %d: %s not in [%s, %s]", attempt, d, max/2, max)
+		}
+	}
+}
+
+func TestExecuteTx_Retry(t *testing.T) {
+	db, node := newFakeDB("master", "mysql")
+	adapter := NewWith(db)
+	ctx := context.Background()
+
+	attempts := 0
+	err := adapter.ExecuteTx(ctx, func(txCtx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return &mysqlError{Number: 1213, Message: "Deadlock found"}
+		}
+		return nil
+	}, WithRetry(5, time.Millisecond))
+	require.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, []string{"BEGIN", "ROLLBACK", "BEGIN", "ROLLBACK", "BEGIN", "COMMIT"}, node.Log())
+
+	attempts = 0
+	err = adapter.ExecuteTx(ctx, func(txCtx context.Context) error {
+		attempts++
+		return &mysqlError{Number: 1213}
+	}, WithRetry(2, time.Millisecond))
+	var txErr *TxError
+	require.True(t, errors.As(err, &txErr))
+	assert.Equal(t, 2, attempts, "stops after max attempts")
+
+	attempts = 0
+	err = adapter.ExecuteTx(ctx, func(txCtx context.Context) error {
+		attempts++
+		return &mysqlError{Number: 1062}
+	}, WithRetry(5, time.Millisecond))
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts, "not retryable")
+}
+
+func TestExecuteTx_NestedPanic(t *testing.T) {
+	db, node := newFakeDB("master", "mysql")
+	adapter := NewWith(db)
+	ctx := context.Background()
+
+	var nestedErr error
+	err := adapter.ExecuteTx(ctx, func(txCtx context.Context) error {
+		nestedErr = adapter.ExecuteTx(txCtx, func(txCtx context.Context) error {
+			panic("boom")
+		}, WithPanicError())
+		return nil
+	})
+	require.NoError(t, err)
+	var panicErr *TxPanicError
+	require.True(t, errors.As(nestedErr, &panicErr))
+	assert.Equal(t, "boom", panicErr.Value)
+	assert.NotEmpty(t, panicErr.Stack)
+	assert.NoError(t, panicErr.Rollback)
+	assert.Equal(t, []string{"BEGIN", "SAVEPOINT sp_1", "ROLLBACK TO SAVEPOINT sp_1", "COMMIT"}, node.Log())
+
+	// without WithPanicError the panic is raised again after the savepoint is rolled back
+	assert.PanicsWithValue(t, "boom", func() {
+		adapter.ExecuteTx(ctx, func(txCtx context.Context) error {
+			return adapter.ExecuteTx(txCtx, func(txCtx context.Context) error {
+				panic("boom")
+			})
+		})
+	})
+}
+
+func TestExecuteTx_Isolation(t *testing.T) {
+	db, node := newFakeDB("master", "mysql")
+	adapter := NewWith(db)
+	fn := func(txCtx context.Context) error {
+		return nil
+	}
+
+	ctx := WithIsolation(context.Background(), sql.LevelSerializable)
+	require.NoError(t, adapter.ExecuteTx(ctx, fn))
+	// the options given to ExecuteTx take precedence over ctx
+	require.NoError(t, adapter.ExecuteTx(ctx, fn, WithTxOptions(&sql.TxOptions{Isolation: sql.LevelReadCommitted, ReadOnly: true})))
+	assert.Equal(t, []string{
+		fmt.Sprintf("BEGIN %d false", sql.LevelSerializable), "COMMIT",
+		fmt.Sprintf("BEGIN %d true", sql.LevelReadCommitted), "COMMIT",
+	}, node.Log())
+}
+
+func TestExecuteTx_Timeout(t *testing.T) {
+	db, node := newFakeDB("master", "mysql")
+	adapter := NewWith(db)
+	ctx := WithTxTimeout(context.Background(), 20*time.Millisecond)
+
+	err := adapter.ExecuteTx(ctx, func(txCtx context.Context) error {
+		<-txCtx.Done()
+		return nil
+	})
+	var txErr *TxError
+	require.True(t, errors.As(err, &txErr))
+	assert.ErrorIs(t, txErr.Commit, context.DeadlineExceeded)
+	// the tx is rolled back by database/sql when the timeout expires
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{"BEGIN", "ROLLBACK"}, node.Log())
+	}, time.Second, time.Millisecond)
+
+	node.Delay = time.Second
+	err = adapter.ExecuteTx(ctx, func(txCtx context.Context) error {
+		_, err := adapter.GetDB(txCtx).ExecContext(txCtx, "UPDATE users SET name = ?", "a")
+		return err
+	})
+	require.True(t, errors.As(err, &txErr))
+	assert.ErrorIs(t, txErr.Callback, context.DeadlineExceeded)
+	assert.NoError(t, txErr.Rollback)
+}
+
+type stubLogger struct {
+	warns chan map[string]interface{}
+}
+
+func (l *stubLogger) Warn(s string, fields map[string]interface{}) {
+	l.warns <- fields
+}
+
+func (l *stubLogger) Info(s string, fields map[string]interface{})  {}
+func (l *stubLogger) Debug(s string, fields map[string]interface{}) {}
+func (l *stubLogger) Error(s string, fields map[string]interface{}) {}
+
+func TestExecuteTx_LongTxWarning(t *testing.T) {
+	threshold := logger.LongTxThreshold
+	logger.LongTxThreshold = 10 * time.Millisecond
+	defer func() {
+		logger.LongTxThreshold = threshold
+	}()
+
+	db, _ := newFakeDB("master", "mysql")
+	adapter := NewWith(db)
+	log := &stubLogger{warns: make(chan map[string]interface{}, 1)}
+	ctx := logger.AttachLogger(context.Background(), log)
+
+	err := adapter.ExecuteTx(ctx, func(txCtx context.Context) error {
+		select {
+		case fields := <-log.warns:
+			assert.Contains(t, fields["stack"], "TestExecuteTx_LongTxWarning")
+			assert.GreaterOrEqual(t, int64(fields["tx_cost"].(time.Duration)), int64(10*time.Millisecond))
+		case <-time.After(time.Second):
+			t.Error("no long tx warning")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	// a tx ended in time is not warned
+	require.NoError(t, adapter.ExecuteTx(ctx, func(txCtx context.Context) error {
+		return nil
+	}))
+	time.Sleep(20 * time.Millisecond)
+	assert.Empty(t, log.warns)
+}
+
+func TestExecuteTx_Propagation(t *testing.T) {
+	testCases := []struct {
+		name        string
+		propagation Propagation
+		outerTx     bool
+		wantErr     error
+		wantTx      bool
+		wantLog     []string
+	}{
+		{"nested without tx", PropagationNested, false, nil, true, []string{"BEGIN", "COMMIT"}},
+		{"nested in tx", PropagationNested, true, nil, true, []string{"BEGIN", "SAVEPOINT sp_1", "RELEASE SAVEPOINT sp_1", "COMMIT"}},
+		{"required without tx", PropagationRequired, false, nil, true, []string{"BEGIN", "COMMIT"}},
+		{"required joins tx", PropagationRequired, true, nil, true, []string{"BEGIN", "COMMIT"}},
+		{"requires new in tx", PropagationRequiresNew, true, nil, true, []string{"BEGIN", "BEGIN", "COMMIT", "COMMIT"}},
+		{"mandatory without tx", PropagationMandatory, false, ErrNoTx, false, nil},
+		{"mandatory joins tx", PropagationMandatory, true, nil, true, []string{"BEGIN", "COMMIT"}},
+		{"never without tx", PropagationNever, false, nil, false, nil},
+		{"never in tx", PropagationNever, true, ErrTxExists, false, []string{"BEGIN", "COMMIT"}},
+		{"supports without tx", PropagationSupports, false, nil, false, nil},
+		{"supports joins tx", PropagationSupports, true, nil, true, []string{"BEGIN", "COMMIT"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, node := newFakeDB("master", "mysql")
+			adapter := NewWith(db)
+
+			var (
+				called bool
+				hasTx  bool
+			)
+			run := func(ctx context.Context) error {
+				return adapter.ExecuteTx(ctx, func(txCtx context.Context) error {
+					called = true
+					hasTx = adapter.HasTx(txCtx)
+					return nil
+				}, WithPropagation(tc.propagation))
+			}
+
+			var err error
+			if tc.outerTx {
+				require.NoError(t, adapter.ExecuteTx(context.Background(), func(txCtx context.Context) error {
+					err = run(txCtx)
+					return nil
+				}))
+			} else {
+				err = run(context.Background())
+			}
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantErr == nil, called)
+			assert.Equal(t, tc.wantTx, hasTx)
+			assert.Equal(t, tc.wantLog, node.Log())
+		})
+	}
+}
+
+func TestViewTx(t *testing.T) {
+	master, masterNode := newFakeDB("master", "mysql")
+	slave, slaveNode := newFakeDB("slave", "mysql")
+	adapter := NewWithCluster([]*sqlx.DB{master}, []*sqlx.DB{slave})
+	fn := func(txCtx context.Context) error {
+		return nil
+	}
+	repeatableRead := WithTxOptions(&sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+
+	ctx := WithSession(context.Background(), time.Minute)
+	require.NoError(t, adapter.ViewTx(ctx, fn))
+	require.NoError(t, adapter.ViewTx(ctx, fn, repeatableRead))
+	assert.Equal(t, []string{"BEGIN 0 true", "COMMIT", fmt.Sprintf("BEGIN %d true", sql.LevelRepeatableRead), "COMMIT"}, slaveNode.Log())
+
+	// the session reads its own write in a read only tx on master
+	GetSession(ctx).MarkWrite()
+	attempts := 0
+	require.NoError(t, adapter.ViewTx(ctx, func(txCtx context.Context) error {
+		attempts++
+		if attempts == 1 {
+			return &mysqlError{Number: 1213}
+		}
+		return nil
+	}, repeatableRead, WithRetry(2, time.Millisecond)))
+	assert.Equal(t, 2, attempts)
+	begin := fmt.Sprintf("BEGIN %d true", sql.LevelRepeatableRead)
+	assert.Equal(t, []string{begin, "ROLLBACK", begin, "COMMIT"}, masterNode.Log())
+}
@@ -0,0 +1,71 @@
+package sqlxx
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type ZoneKey struct{}
+
+var localZone atomic.Value
+
+// SetLocalZone sets the zone of the running process, it is used when ctx has no zone.
+func SetLocalZone(zone string) {
+	localZone.Store(zone)
+}
+
+func LocalZone() string {
+	zone, _ := localZone.Load().(string)
+	return zone
+}
+
+func WithZone(ctx context.Context, zone string) context.Context {
+	return context.WithValue(ctx, ZoneKey{}, zone)
+}
+
+// GetZone returns the zone of the caller, it falls back to LocalZone.
+func GetZone(ctx context.Context) string {
+	zone, ok := ctx.Value(ZoneKey{}).(string)
+	if ok && zone != "" {
+		return zone
+	}
+	return LocalZone()
+}
+
+// TagZone labels db with zone, slaves in the zone of the caller are preferred.
+func (c *Cluster) TagZone(db *sqlx.DB, zone string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.zones == nil {
+		c.zones = make(map[*sqlx.DB]string)
+	}
+	c.zones[db] = zone
+}
+
+func (c *Cluster) Zone(db *sqlx.DB) string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.zones[db]
+}
+
+// getSlave prefers the slaves in the zone of ctx and falls back to the other
+// zones only if none of them is available.
+func (c *Cluster) getSlave(ctx context.Context) (*sqlx.DB, error) {
+	zone := GetZone(ctx)
+	c.lock.RLock()
+	zoned := len(c.zones) > 0
+	c.lock.RUnlock()
+
+	if zone != "" && zoned {
+		db, err := c.slaves.Get(WithNodeFilter(ctx, func(db *sqlx.DB) bool {
+			return c.Zone(db) == zone
+		}))
+		if !errors.Is(err, ErrNoHealthyNode) {
+			return db, err
+		}
+	}
+	return c.slaves.Get(ctx)
+}
@@ -0,0 +1,69 @@
+package sqlxx
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newZoneCluster() (*Cluster, map[string]*sqlx.DB) {
+	master, _ := newFakeDB("master", "mysql")
+	a1, _ := newFakeDB("a1", "mysql")
+	a2, _ := newFakeDB("a2", "mysql")
+	b1, _ := newFakeDB("b1", "mysql")
+	cluster := NewRRCluster([]*sqlx.DB{master}, []*sqlx.DB{a1, b1, a2})
+	cluster.TagZone(a1, "a")
+	cluster.TagZone(a2, "a")
+	cluster.TagZone(b1, "b")
+	return cluster, map[string]*sqlx.DB{"a1": a1, "a2": a2, "b1": b1}
+}
+
+func TestCluster_SameZone(t *testing.T) {
+	cluster, nodes := newZoneCluster()
+	ctx := WithSlave(WithZone(context.Background(), "a"))
+
+	picked := make(map[*sqlx.DB]int)
+	for i := 0; i < 6; i++ {
+		db, err := cluster.GetDB(ctx)
+		require.NoError(t, err)
+		picked[db]++
+	}
+	assert.Equal(t, map[*sqlx.DB]int{nodes["a1"]: 3, nodes["a2"]: 3}, picked)
+	assert.Equal(t, "b", cluster.Zone(nodes["b1"]))
+}
+
+func TestCluster_ZoneFallback(t *testing.T) {
+	cluster, nodes := newZoneCluster()
+	// the nodes of zone a are filtered out, e.g. ejected by the health check
+	ctx := WithNodeFilter(WithSlave(WithZone(context.Background(), "a")), func(db *sqlx.DB) bool {
+		return cluster.Zone(db) != "a"
+	})
+
+	db, err := cluster.GetDB(ctx)
+	require.NoError(t, err)
+	assert.Same(t, nodes["b1"], db)
+
+	// the zone without nodes falls back to every zone
+	db, err = cluster.GetDB(WithSlave(WithZone(context.Background(), "c")))
+	require.NoError(t, err)
+	assert.NotNil(t, db)
+}
+
+func TestCluster_LocalZone(t *testing.T) {
+	SetLocalZone("b")
+	defer SetLocalZone("")
+	cluster, nodes := newZoneCluster()
+
+	db, err := cluster.GetDB(WithSlave(context.Background()))
+	require.NoError(t, err)
+	assert.Same(t, nodes["b1"], db)
+
+	// the zone of ctx takes precedence over the local zone
+	assert.Equal(t, "a", GetZone(WithZone(context.Background(), "a")))
+	db, err = cluster.GetDB(WithSlave(WithZone(context.Background(), "a")))
+	require.NoError(t, err)
+	assert.Equal(t, "a", cluster.Zone(db))
+}